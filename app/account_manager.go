@@ -8,7 +8,6 @@ import (
 	"reflect"
 	"sync"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/howeyc/fsnotify"
 	"github.com/uget/uget/core"
 	"github.com/uget/uget/utils"
@@ -47,6 +46,7 @@ type internalAccMgr struct {
 	*utils.Jobber
 	file string
 	root root
+	log  core.Logger // guarded by mtx
 }
 
 // AccountManager manages provider accounts and keeps the accounts file and local memory in sync
@@ -59,28 +59,46 @@ var mtx = sync.Mutex{}
 var managers = map[string]*internalAccMgr{}
 
 // AccountManagerFor returns an AccountManager for the given file and provider. File can be empty.
+// It does not log, see AccountManagerWith.
 func AccountManagerFor(file string, p core.Accountant) *AccountManager {
+	return AccountManagerWith(file, p, nil)
+}
+
+// AccountManagerWith is like AccountManagerFor, the manager of the file logs to log unless that is nil.
+func AccountManagerWith(file string, p core.Accountant, log core.Logger) *AccountManager {
 	if file == "" {
 		file = defaultFile()
 	}
-	return &AccountManager{managerFor(file), p}
+	return &AccountManager{managerFor(file, log), p}
 }
 
-func managerFor(file string) *internalAccMgr {
+func managerFor(file string, log core.Logger) *internalAccMgr {
 	mtx.Lock()
 	defer mtx.Unlock()
+	if log == nil {
+		log = core.NopLogger
+	}
 	if managers[file] == nil {
 		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
-			logrus.Errorf("core.managerFor: could not create parent dirs of %s", file)
+			log.Errorf("core.managerFor: could not create parent dirs of %s", file)
 			return nil
 		}
-		m := &internalAccMgr{utils.NewJobber(), file, nil}
+		m := &internalAccMgr{utils.NewJobber(), file, nil, log}
 		managers[file] = m
 		go m.dispatch()
+	} else if log != core.NopLogger {
+		// the manager of the file is shared, it logs to the latest logger
+		managers[file].log = log
 	}
 	return managers[file]
 }
 
+func (m *internalAccMgr) logger() core.Logger {
+	mtx.Lock()
+	defer mtx.Unlock()
+	return m.log
+}
+
 func (m *AccountManager) Metadata() []*accinfo {
 	var accs []*accinfo
 	<-m.Job(func() {
@@ -201,23 +219,23 @@ func (m *internalAccMgr) reload() {
 		if os.IsNotExist(err) {
 			f, err = os.Create(m.file)
 			if err != nil {
-				logrus.Errorf("internalAccMgr#reload: create %s: %v", m.file, err)
+				m.logger().Errorf("internalAccMgr#reload: create %s: %v", m.file, err)
 			} else {
 				defer f.Close()
 				_, err = f.WriteString("{}")
 				if err != nil {
-					logrus.Errorf("internalAccMgr#reload: write %s: %v", m.file, err)
+					m.logger().Errorf("internalAccMgr#reload: write %s: %v", m.file, err)
 				}
 			}
 			return
 		}
-		logrus.Errorf("internalAccMgr#reload: open %s: %v", m.file, err)
+		m.logger().Errorf("internalAccMgr#reload: open %s: %v", m.file, err)
 		return
 	}
 	defer f.Close()
 	bytes, err := ioutil.ReadAll(f)
 	if err != nil {
-		logrus.Errorf("internalAccMgr#reload: read %s: %v", m.file, err)
+		m.logger().Errorf("internalAccMgr#reload: read %s: %v", m.file, err)
 		return
 	}
 	json.Unmarshal(bytes, &m.root)
@@ -227,11 +245,11 @@ func (m *internalAccMgr) dispatch() {
 	m.reload()
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		logrus.Errorf("internalAccMgr#dispatch: could not initialize file watcher")
+		m.logger().Errorf("internalAccMgr#dispatch: could not initialize file watcher")
 	} else {
 		defer watcher.Close()
 		if err = watcher.Watch(m.file); err != nil {
-			logrus.Errorf("internalAccMgr#dispatch: cannot watch %s", m.file)
+			m.logger().Errorf("internalAccMgr#dispatch: cannot watch %s", m.file)
 		}
 	}
 	for {
@@ -241,17 +259,17 @@ func (m *internalAccMgr) dispatch() {
 				m.reload()
 			}
 		case err := <-watcher.Error:
-			logrus.Errorf("internalAccMgr#dispatch: error watching %s: %v", m.file, err)
+			m.logger().Errorf("internalAccMgr#dispatch: error watching %s: %v", m.file, err)
 		case job, ok := <-m.JobQueue:
 			if !ok {
-				logrus.Debugf("internalAccMgr#dispatch: closing.")
+				m.logger().Debugf("internalAccMgr#dispatch: closing.")
 				return
 			}
 			job.Work()
 			if err := m.save(); err != nil {
-				logrus.Errorf("internalAccMgr#dispatch: error saving %v", m.file)
+				m.logger().Errorf("internalAccMgr#dispatch: error saving %v", m.file)
 			} else {
-				logrus.Debugf("internalAccMgr#dispatch: sucess saving %v", m.file)
+				m.logger().Debugf("internalAccMgr#dispatch: sucess saving %v", m.file)
 			}
 			// this is after m.save() because of race conditions that occur if main thread exits.
 			// TODO: fix the race condition and move this up.
@@ -0,0 +1,27 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/uget/uget/core"
+	"github.com/uget/uget/utils"
+)
+
+// LoadProviderConfig reads the provider config from the given file. File can be empty.
+// A missing file yields the zero config, which keeps the registered order.
+func LoadProviderConfig(file string) (core.ProviderConfig, error) {
+	var cfg core.ProviderConfig
+	if file == "" {
+		file = utils.ProvidersPath()
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
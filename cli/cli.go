@@ -9,38 +9,62 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/jessevdk/go-flags"
 	"github.com/uget/uget/core"
+	"github.com/uget/uget/utils"
 )
 
 /* CLI specification */
 
 type options struct {
-	Accounts accounts `command:"accounts"`
-	Get      get      `command:"get"`
-	Resolve  resolve  `command:"meta"`
-	Server   server   `command:"server"`
-	Daemon   daemon   `command:"daemon"`
-	Push     push     `command:"push"`
-	Version  version  `command:"version"`
-	Unknowns map[string]string
+	Accounts  accounts  `command:"accounts"`
+	Get       get       `command:"get"`
+	Resolve   resolve   `command:"meta"`
+	Server    server    `command:"server"`
+	Daemon    daemon    `command:"daemon"`
+	Push      push      `command:"push"`
+	Providers providers `command:"providers"`
+	Stats     stats     `command:"stats"`
+	Version   version   `command:"version"`
+	LogLevel  string    `long:"log-level" default:"info" choice:"debug" choice:"info" choice:"warn" choice:"error" description:"Log messages of this level and above"`
+	LogFile   string    `long:"log-file" description:"Log into this file instead of the default one in the uget data directory (or stderr if it is not a terminal)"`
+	LogFormat string    `long:"log-format" default:"text" choice:"text" choice:"json" description:"Format of the log messages"`
+	Unknowns  map[string]string
 }
 
 type version struct{}
 
 type server struct {
-	Port     uint16 `short:"p" long:"port" description:"port the server listens on" default:"9666"`
-	BindAddr string `short:"b" long:"bind" description:"address to bind the server to"`
+	Port      uint16   `short:"p" long:"port" description:"port the server listens on" default:"9666"`
+	BindAddr  string   `short:"b" long:"bind" description:"address to bind the server to"`
+	Windows   []string `short:"w" long:"window" description:"Timetable window, e.g. 'mon-fri 09:00-17:00 limit=200k' or '* 07:00-23:00 pause'. Can be repeated"`
+	Bandwidth string   `long:"bandwidth" description:"Bandwidth limit per second outside of the windows, e.g. 2M"`
+	AutoJobs  string   `long:"auto-jobs" description:"Adjust the parallel downloads to the throughput, within MIN-MAX, e.g. 1-8"`
 }
 
 type urlArgs struct {
-	Inline bool `short:"i" long:"inline" description:"Interpret arguments as URLs (instead of files)"`
+	Inline   bool     `short:"i" long:"inline" description:"Interpret arguments as URLs (instead of files)"`
+	Rewrites []string `long:"rewrite" description:"Rewrite links before resolving them, e.g. '^http:=>https:' (can be repeated)"`
 }
 
 type get struct {
 	*urlArgs
-	DryRun     bool `short:"n" long:"dry-run" description:"Just output instead of downloading."`
-	NoContinue bool `short:"C" long:"no-continue" description:"Redownload entire file instead of continuing previous download."`
-	NoSkip     bool `short:"S" long:"no-skip" description:"Redownload file even if size is correct"`
-	Jobs       int  `short:"j" long:"jobs" default:"3" description:"Jobs to run in parallel"`
+	DryRun     bool          `short:"n" long:"dry-run" description:"Just output instead of downloading."`
+	NoContinue bool          `short:"C" long:"no-continue" description:"Redownload entire file instead of continuing previous download."`
+	NoSkip     bool          `short:"S" long:"no-skip" description:"Redownload file even if size is correct"`
+	Jobs       int           `short:"j" long:"jobs" default:"3" description:"Jobs to run in parallel"`
+	Resolvers  int           `long:"resolvers" default:"8" description:"Links to resolve in parallel"`
+	Timeout    time.Duration `long:"provider-timeout" default:"2m" description:"Abort a provider's attempt to resolve a link or request a download after this long, 0 disables"`
+	Session    string        `long:"session" description:"Journal the downloads to this file, continuing those it already holds"`
+	Schedule   string        `long:"schedule" default:"priority" choice:"priority" choice:"fifo" choice:"round-robin" choice:"smallest" choice:"provider" description:"Order in which resolved files are downloaded"`
+	AutoJobs   string        `long:"auto-jobs" description:"Adjust the jobs to the throughput, within MIN-MAX, e.g. 1-8"`
+	Retries    int           `long:"retries" default:"2" description:"Retry failed and stalled downloads this often"`
+	Stall      time.Duration `long:"stall-timeout" default:"1m" description:"Reconnect downloads that received nothing for this long, 0 disables"`
+	MinSpeed   string        `long:"min-speed" description:"Reconnect downloads slower than SIZE per second for DURATION, e.g. 10k/30s"`
+	Via        string        `long:"via" description:"Retrieve all files with this provider"`
+	ResolveVia string        `long:"resolve-via" description:"Resolve all links with this provider"`
+	Exclude    []string      `long:"exclude-provider" description:"Never use this provider (can be repeated)"`
+	NoStats    bool          `long:"no-stats" description:"Neither record nor consult how the providers performed"`
+	UserAgent  string        `long:"user-agent" description:"User agent of all HTTP requests (default: uget/VERSION)"`
+	Rate       float64       `long:"request-rate" description:"Send at most this many HTTP requests per second to each host, 0 means unlimited"`
 }
 
 type resolve struct {
@@ -50,8 +74,14 @@ type resolve struct {
 	Remove  bool `short:"r" long:"remove" description:"Remove if file does not compare (only works with -c)."`
 }
 
-type daemon struct{}
+type daemon struct {
+	server
+}
 type push struct{}
+type providers struct{}
+type stats struct {
+	Reset bool `long:"reset" description:"Forget the recorded statistics"`
+}
 
 type accounts struct {
 	Add     accountsAdd     `command:"add"`
@@ -106,6 +136,14 @@ func (cmd *push) Execute(args []string) error {
 	return command(args, cmdPush)
 }
 
+func (cmd *providers) Execute(args []string) error {
+	return command(args, cmdProviders)
+}
+
+func (cmd *stats) Execute(args []string) error {
+	return command(args, cmdStats)
+}
+
 // Command facilitates calling commands with options.
 type Command func(*options) int
 
@@ -121,8 +159,6 @@ func command(args []string, f func([]string, *options) int) error {
 
 // RunApp sets up parser and runs app with passed arguments. Returns exit code.
 func RunApp(arguments []string) int {
-	logrus.Infof("==== uget %v - %v ====", core.Version, time.Now().Local().Format("15:04:05"))
-	logrus.Infof("==== running with args %s", core.Version, strings.Join(arguments[1:], " "))
 	opts := &options{
 		Unknowns: map[string]string{},
 	}
@@ -151,6 +187,12 @@ func RunApp(arguments []string) int {
 		} else {
 			cmd, ok := err.(Command)
 			if ok {
+				if err = utils.InitLogger(opts.LogLevel, opts.LogFile, opts.LogFormat); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return 1
+				}
+				logrus.Infof("==== uget %v - %v ====", core.Version, time.Now().Local().Format("15:04:05"))
+				logrus.Infof("==== running with args %s", strings.Join(arguments[1:], " "))
 				return cmd(opts)
 			}
 		}
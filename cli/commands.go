@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/mattn/go-isatty"
 	"github.com/uget/uget/app"
 	"github.com/uget/uget/core"
 	api "github.com/uget/uget/server"
+	"github.com/uget/uget/utils"
 	"github.com/uget/uget/utils/console"
 	"github.com/uget/uget/utils/rate"
 	"github.com/uget/uget/utils/units"
@@ -55,7 +60,7 @@ func cmdListAccounts(args []string, opt *options) int {
 	for _, p := range providers {
 		pp, ok := p.(core.Accountant)
 		if ok {
-			accs := app.AccountManagerFor("", pp).Metadata()
+			accs := app.AccountManagerWith("", pp, logger()).Metadata()
 			fmt.Printf("%s:\n", p.Name())
 			for _, acc := range accs {
 				fmt.Printf("    %v", acc.Data)
@@ -78,7 +83,7 @@ func cmdDisableAccount(args []string, opt *options) int {
 	if provider == nil {
 		return 1
 	}
-	mgr := app.AccountManagerFor("", provider.(core.Accountant))
+	mgr := app.AccountManagerWith("", provider.(core.Accountant), logger())
 	accounts := mgr.Accounts()
 	ids := make([]string, len(accounts))
 	for i, acc := range accounts {
@@ -102,7 +107,7 @@ func cmdEnableAccount(args []string, opt *options) int {
 	if provider == nil {
 		return 1
 	}
-	mgr := app.AccountManagerFor("", provider.(core.Accountant))
+	mgr := app.AccountManagerWith("", provider.(core.Accountant), logger())
 	accounts := mgr.Metadata()
 	ids := make([]string, 0, len(accounts))
 	for _, acc := range accounts {
@@ -120,13 +125,29 @@ func cmdEnableAccount(args []string, opt *options) int {
 }
 
 func cmdResolve(args []string, opts *options) int {
-	urls := grabURLs(args, opts.Resolve.urlArgs)
+	urls, overrides := grabURLs(args, opts.Resolve.urlArgs)
 	if urls == nil {
 		return 1
 	}
 	client := core.NewClient()
+	client.Log = logger()
+	useHTTP(client, "", 0)
 	useAccounts(client)
-	wg := client.AddURLs(urls)
+	if err := useProviderConfig(client); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := useRewrites(client, opts.Resolve.Rewrites); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, choice := range overrides {
+		if err := checkChoices(client.Providers, choice); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	wg := client.AddURLsWith(urls, core.ContainerOptions{Overrides: overrides})
 	client.Resolve()
 	wg.Wait()
 	client.Finalize()
@@ -134,8 +155,11 @@ func cmdResolve(args []string, opts *options) int {
 	var unknownFactor bool
 	ret := 0
 	for file := range client.ResolvedQueue.Dequeue() {
-		if file.Err() != nil {
-			fmt.Printf("errored     %s - %v\n", file.URL(), file.Err().Error())
+		if unsupported(file.Err()) {
+			fmt.Printf("unsupported %s - %v\n", file.URL(), file.Err())
+			unknownFactor = true
+		} else if file.Err() != nil {
+			fmt.Printf("errored     %s - %s\n", file.URL(), describe(file.Err()))
 			unknownFactor = true
 			ret = 1
 		} else if file.Offline() {
@@ -216,22 +240,94 @@ func cmdVersion(args []string, opts *options) int {
 }
 
 func cmdGet(args []string, opts *options) int {
-	urls := grabURLs(args, opts.Get.urlArgs)
-	if urls == nil {
-		return 1
+	var session *core.Session
+	if opts.Get.Session != "" && !opts.Get.DryRun {
+		var err error
+		if session, err = core.OpenSession(opts.Get.Session); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading session %s: %v\n", opts.Get.Session, err)
+			return 1
+		}
+	}
+	var urls []*url.URL
+	containerOpts := core.ContainerOptions{
+		Providers: core.ProviderChoice{Resolve: opts.Get.ResolveVia, Retrieve: opts.Get.Via, Exclude: opts.Get.Exclude},
+	}
+	// an unfinished session can be continued without providing new links.
+	if session == nil || session.Len() == 0 || len(args) != 0 {
+		if urls, containerOpts.Overrides = grabURLs(args, opts.Get.urlArgs); urls == nil {
+			return 1
+		}
 	}
 	if opts.Get.Jobs < 1 {
 		opts.Get.Jobs = 1
 	}
 	downloader := core.NewClientWith(opts.Get.Jobs)
+	downloader.Log = logger()
+	useHTTP(downloader, opts.Get.UserAgent, opts.Get.Rate)
 	useAccounts(downloader)
+	if err := useProviderConfig(downloader); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := useRewrites(downloader, opts.Get.Rewrites); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
 	downloader.NoSkip = opts.Get.NoSkip
 	downloader.NoContinue = opts.Get.NoContinue
-	wg := downloader.AddURLs(urls)
+	downloader.Schedule = core.SchedulePolicyFor(opts.Get.Schedule)
+	downloader.Retry.Attempts = opts.Get.Retries
+	downloader.Resolvers = opts.Get.Resolvers
+	downloader.Timeout = opts.Get.Timeout
+	downloader.Watchdog.Timeout = opts.Get.Stall
+	if opts.Get.MinSpeed != "" {
+		speed, period, err := parseMinSpeed(opts.Get.MinSpeed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		downloader.Watchdog.MinSpeed, downloader.Watchdog.Period = speed, period
+	}
+	if opts.Get.AutoJobs != "" {
+		autoscale, err := parseAutoscale(opts.Get.AutoJobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		downloader.Autoscale = autoscale
+	}
+	choices := []core.ProviderChoice{containerOpts.Providers}
+	for _, choice := range containerOpts.Overrides {
+		choices = append(choices, choice)
+	}
+	if err := checkChoices(downloader.Providers, choices...); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !opts.Get.DryRun && !opts.Get.NoStats {
+		stats, err := core.OpenStats(utils.StatsPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading provider statistics: %v\n", err)
+			return 1
+		}
+		downloader.Stats = stats
+	}
+	downloader.Session = session
+	containers := downloader.Restore()
+	if urls != nil {
+		containers = append(containers, downloader.AddURLsWith(urls, containerOpts))
+	}
+	if len(containers) == 0 {
+		// e.g. the containers of the session could not be restored. Nothing would ever finish.
+		fmt.Fprintln(os.Stderr, "Nothing to download.")
+		return 0
+	}
 	if opts.Get.DryRun {
 		logrus.SetOutput(os.Stderr)
 		downloader.DryRun()
-		wg.Wait()
+		for _, c := range containers {
+			c.Wait()
+		}
 		return 0
 	}
 	exit := 0
@@ -243,54 +339,152 @@ func cmdGet(args []string, opts *options) int {
 	}
 	rootRater := rate.SmoothRate(10)
 	con.Add(func() string {
-		return fmt.Sprintf("TOTAL %9s/s", units.BytesSize(float64(rootRater.Rate())))
+		return fmt.Sprintf("TOTAL %9s/s, %d jobs", units.BytesSize(float64(rootRater.Rate())), downloader.Concurrency())
+	})
+	// final row texts of running downloads. Only accessed by the (sequential) event handlers.
+	finals := map[*core.Download]*atomic.Value{}
+	vias := map[*core.Download]string{}
+	// final row texts of waiting files, by name
+	waits := map[string]*atomic.Value{}
+	endWait := func(name, text string) {
+		if final, ok := waits[name]; ok {
+			final.Store(text)
+			delete(waits, name)
+		}
+	}
+	downloader.OnWait(func(e core.WaitEvent) {
+		name, reason := e.File.Name(), e.Err.Reason
+		if reason == "" {
+			reason = "waiting"
+		}
+		endWait(name, fmt.Sprintf("%s: waited.", name))
+		final := new(atomic.Value)
+		waits[name] = final
+		con.Insert(-1, func() string {
+			if text, ok := final.Load().(string); ok {
+				return text
+			}
+			left := time.Until(e.Until)
+			if left <= 0 {
+				// requests that wait to be resolved again may resolve to files of other names
+				return fmt.Sprintf("%s: waited.", name)
+			}
+			return fmt.Sprintf("%s: %s, retrying in %s", name, reason, prettyTime(left))
+		})
 	})
 	downloader.OnDownload(func(download *core.Download) {
+		endWait(download.File.Name(), fmt.Sprintf("%s: waited.", download.File.Name()))
 		prog := download.Progress()
-		start := time.Now()
 		rater := rate.SmoothRate(10)
 		var via string
 		if download.Provider != download.File.Provider() {
 			via = fmt.Sprintf(" (via %s)", download.Provider.Name())
 		}
+		final := new(atomic.Value)
+		finals[download], vias[download] = final, via
 		con.Insert(-1, func() string {
-			if download.Done() {
-				if download.Err() != nil {
-					return fmt.Sprintf("%s: error: %v", download.File.Name(), download.Err())
-				} else if download.Canceled() {
-					return fmt.Sprintf("%s: stopped.", download.File.Name())
-				} else {
-					name := download.File.Name()
-					download := units.BytesSize(float64(download.Progress()))
-					pt := prettyTime(time.Since(start))
-					return fmt.Sprintf("%s: downloaded %s in %s%s", name, download, pt, via)
-				}
-			} else {
-				progress := download.Progress()
-				diff := progress - prog
-				prog = progress
-				rater.Add(diff)
-				rootRater.Add(diff)
-				return fprog(download.File.Name(), float64(prog), float64(download.Size()), float64(rater.Rate()), via)
+			if text, ok := final.Load().(string); ok {
+				return text
 			}
+			progress := download.Progress()
+			diff := progress - prog
+			prog = progress
+			rater.Add(diff)
+			rootRater.Add(diff)
+			return fprog(download.File.Name(), float64(prog), float64(download.Size()), float64(rater.Rate()), via)
 		})
 	})
+	downloader.OnComplete(func(e core.CompleteEvent) {
+		name := e.Download.File.Name()
+		if e.Err == core.ErrStalled {
+			finals[e.Download].Store(fmt.Sprintf("%s: stalled, reconnecting...", name))
+		} else if e.Err != nil {
+			finals[e.Download].Store(fmt.Sprintf("%s: error: %s", name, describe(e.Err)))
+		} else {
+			var verified string
+			if e.Verified {
+				verified = ", verified"
+			}
+			size := units.BytesSize(float64(e.Bytes))
+			speed := units.BytesSize(e.Speed)
+			text := fmt.Sprintf("%s: downloaded %s in %s @ %s/s%s%s", name, size, prettyTime(e.Duration), speed, vias[e.Download], verified)
+			finals[e.Download].Store(text)
+		}
+		delete(finals, e.Download)
+		delete(vias, e.Download)
+	})
+	downloader.OnCancel(func(e core.CancelEvent) {
+		endWait(e.File.Name(), fmt.Sprintf("%s: stopped.", e.File.Name()))
+		if e.Download != nil {
+			finals[e.Download].Store(fmt.Sprintf("%s: stopped.", e.File.Name()))
+			delete(finals, e.Download)
+			delete(vias, e.Download)
+		}
+	})
 	downloader.OnSkip(func(file core.File) {
 		con.InsertConst(-1, fmt.Sprintf("%s: skipped...", file.Name()))
 	})
 	downloader.OnError(func(f core.File, err error) {
 		exit = 1
-		con.InsertConst(-1, fmt.Sprintf("%v: error: %v.", f.Name(), err))
+		con.InsertConst(-1, fmt.Sprintf("%v: error: %s.", f.Name(), describe(err)))
+	})
+	// a container is done after all events of its files, so all rows are final once they are.
+	done := make(chan struct{})
+	pending := len(containers)
+	downloader.OnContainerDone(func(e core.ContainerDoneEvent) {
+		if pending--; pending == 0 {
+			close(done)
+		}
 	})
 	downloader.Start()
-	wg.Wait()
+	// unless the links were read from it, the terminal can adjust the jobs.
+	if isatty.IsTerminal(os.Stdin.Fd()) && (urls == nil || !readsStdin(args, opts.Get.urlArgs)) {
+		con.InsertConst(0, "Type +, - or a number and press enter to change the jobs.")
+		go controlJobs(downloader)
+	}
+	<-done
+	if downloader.Stats != nil {
+		if err := downloader.Stats.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing provider statistics: %v\n", err)
+		}
+	}
 	return exit
 }
 
 func cmdServer(args []string, opts *options) int {
-	server := &api.Server{}
+	server := &api.Server{Log: logger()}
 	server.BindAddr = opts.Server.BindAddr
 	server.Port = opts.Server.Port
+	for _, spec := range opts.Server.Windows {
+		w, err := core.ParseWindow(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		server.Timetable = append(server.Timetable, w)
+	}
+	if opts.Server.Bandwidth != "" {
+		bps, err := units.RAMInBytes(opts.Server.Bandwidth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid bandwidth %s: %v\n", opts.Server.Bandwidth, err)
+			return 1
+		}
+		server.Bandwidth = bps
+	}
+	if opts.Server.AutoJobs != "" {
+		autoscale, err := parseAutoscale(opts.Server.AutoJobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		server.Autoscale = autoscale
+	}
+	cfg, err := app.LoadProviderConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not read the provider config: %v\n", err)
+		return 1
+	}
+	server.Providers = cfg
 	if server.Port != 9666 {
 		fmt.Fprintln(os.Stderr, "Click'n'Load v2 will only work for port 9666!")
 	}
@@ -299,7 +493,7 @@ func cmdServer(args []string, opts *options) int {
 }
 
 func cmdDaemon(args []string, opts *options) int {
-	cmd := exec.Command(os.Args[0], append([]string{"server"}, os.Args[2:]...)...)
+	cmd := exec.Command(os.Args[0], daemonArgs(args, opts)...)
 	fi, err := os.OpenFile("server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		logrus.Error(err)
@@ -314,6 +508,62 @@ func cmdDaemon(args []string, opts *options) int {
 	return 0
 }
 
+func cmdProviders(args []string, opts *options) int {
+	cfg, err := app.LoadProviderConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: could not read the provider config: %v\n", err)
+		return 1
+	}
+	registered := core.RegisteredProviders()
+	enabled := cfg.Apply(registered)
+	row := func(position string, p core.Provider, status string) {
+		fmt.Printf("%3s  %-20s %-26s", position, p.Name(), strings.Join(capabilities(p), ", "))
+		if bias := cfg.Providers[p.Name()].Bias; bias != 0 {
+			fmt.Printf(" bias %+d", bias)
+		}
+		fmt.Println(status)
+	}
+	for i, p := range enabled {
+		row(fmt.Sprintf("%d.", i+1), p, "")
+	}
+	for _, p := range registered {
+		if enabled.GetProvider(p.Name()) == nil {
+			row("-", p, " (disabled)")
+		}
+	}
+	return 0
+}
+
+func cmdStats(args []string, opts *options) int {
+	if opts.Stats.Reset {
+		if err := os.Remove(utils.StatsPath()); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	stats, err := core.OpenStats(utils.StatsPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading provider statistics: %v\n", err)
+		return 1
+	}
+	list := stats.List()
+	if len(list) == 0 {
+		fmt.Println("No statistics recorded yet.")
+		return 0
+	}
+	fmt.Printf("%-30s %8s %8s %11s %9s\n", "PROVIDER", "ATTEMPTS", "SUCCESS", "THROUGHPUT", "TTFB")
+	for _, st := range list {
+		name := st.Provider
+		if st.Account != "" {
+			name = "  " + st.Account
+		}
+		fmt.Printf("%-30s %8d %7.1f%% %9s/s %9v\n", name, st.Attempts, st.SuccessRate()*100,
+			units.BytesSize(st.Throughput()), st.TimeToFirstByte().Round(time.Millisecond))
+	}
+	return 0
+}
+
 func cmdPush(args []string, opts *options) int {
 	logrus.Error("Not implemented yet.")
 	return 3
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -14,26 +15,41 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/uget/uget/app"
 	"github.com/uget/uget/core"
+	"github.com/uget/uget/core/api"
+	"github.com/uget/uget/utils/units"
 )
 
-func urlsFromFilename(urls *[]*url.URL, f string) error {
+func urlsFromFilename(urls *[]*url.URL, overrides map[string]core.ProviderChoice, f string) error {
 	file, err := os.Open(f)
 	if err != nil {
 		logrus.Errorf("helpers.urlsFromFile: could not open %v", f)
 		return err
 	}
 	defer file.Close()
-	return urlsFromFile(urls, file)
+	return urlsFromFile(urls, overrides, file)
 }
 
-func urlsFromFile(urls *[]*url.URL, file *os.File) error {
+// urlsFromFile reads one link per line. A link can be followed by provider choices,
+// e.g. `https://host/file via=premium exclude=free,other`, which are stored in overrides.
+func urlsFromFile(urls *[]*url.URL, overrides map[string]core.ProviderChoice, file *os.File) error {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		u, err := url.Parse(scanner.Text())
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		u, err := url.Parse(fields[0])
 		if err != nil {
 			logrus.Errorf("helpers.urlsFromFile: url parse: %v", err)
 			return err
 		}
+		if len(fields) > 1 {
+			choice, err := parseChoice(fields[1:])
+			if err != nil {
+				return fmt.Errorf("%v: %v", u, err)
+			}
+			overrides[u.String()] = choice
+		}
 		*urls = append(*urls, u)
 	}
 
@@ -44,19 +60,21 @@ func urlsFromFile(urls *[]*url.URL, file *os.File) error {
 	return nil
 }
 
-func grabURLs(args []string, opts *urlArgs) []*url.URL {
+// grabURLs returns the links the arguments denote, along with the provider choices made in the files.
+func grabURLs(args []string, opts *urlArgs) ([]*url.URL, map[string]core.ProviderChoice) {
 	var urls []*url.URL
+	overrides := make(map[string]core.ProviderChoice)
 	if opts.Inline {
 		urls = make([]*url.URL, 0, len(args))
 		for i, link := range args {
 			u, err := url.Parse(link)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Couldn't parse provided link %s (#%d): %s.", link, i+1, err.Error())
-				return nil
+				return nil, nil
 			}
 			if !u.IsAbs() {
 				fmt.Fprintf(os.Stderr, "Provided link %s (#%d) must be an absolute URL.\n", link, i+1)
-				return nil
+				return nil, nil
 			}
 			urls = append(urls, u)
 		}
@@ -71,21 +89,58 @@ func grabURLs(args []string, opts *urlArgs) []*url.URL {
 				if isatty.IsTerminal(os.Stdin.Fd()) {
 					fmt.Println("Enter your links:")
 				}
-				err = urlsFromFile(&urls, os.Stdin)
+				err = urlsFromFile(&urls, overrides, os.Stdin)
 			} else {
-				err = urlsFromFilename(&urls, file)
+				err = urlsFromFilename(&urls, overrides, file)
 			}
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error reading links: %v\n", err)
-				return nil
+				return nil, nil
 			}
 		}
 	}
 	if len(urls) == 0 {
 		fmt.Fprintln(os.Stderr, "No URLs provided")
-		return nil
+		return nil, nil
+	}
+	return urls, overrides
+}
+
+// parseChoice reads the provider choices following a link in a file:
+// `via=NAME` retrieves its files with that provider, `resolve-via=NAME` resolves the link with it,
+// and `exclude=NAME,...` never uses the listed providers.
+func parseChoice(fields []string) (core.ProviderChoice, error) {
+	var choice core.ProviderChoice
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return choice, fmt.Errorf("invalid provider choice %q", field)
+		}
+		switch kv[0] {
+		case "via":
+			choice.Retrieve = kv[1]
+		case "resolve-via":
+			choice.Resolve = kv[1]
+		case "exclude":
+			choice.Exclude = append(choice.Exclude, strings.Split(kv[1], ",")...)
+		default:
+			return choice, fmt.Errorf("unknown provider choice %q", kv[0])
+		}
+	}
+	return choice, nil
+}
+
+// checkChoices returns an error if one of the choices names a provider that does not exist.
+func checkChoices(ps core.Providers, choices ...core.ProviderChoice) error {
+	for _, choice := range choices {
+		names := append([]string{choice.Resolve, choice.Retrieve}, choice.Exclude...)
+		for _, name := range names {
+			if name != "" && ps.GetProvider(name) == nil {
+				return fmt.Errorf("no provider named %s", name)
+			}
+		}
 	}
-	return urls
+	return nil
 }
 
 func selectPProvider(arg string) core.Provider {
@@ -149,7 +204,7 @@ func userSelection(arr []string, prompt string, tries uint8) (int, error) {
 			fmt.Fprintf(os.Stdout, "Invalid selection: %v!\n\n", invalid)
 			return userSelection(arr, prompt, tries-1)
 		}
-		return 0, fmt.Errorf(invalid)
+		return 0, errors.New(invalid)
 	}
 	return i, nil
 }
@@ -224,7 +279,7 @@ func tryAddAccount(p core.Provider, pr core.Prompter) error {
 	acct, ok := p.(core.Accountant)
 	if ok {
 		if acc, err := acct.NewAccount(pr); err == nil {
-			app.AccountManagerFor("", acct).AddAccount(acc)
+			app.AccountManagerWith("", acct, logger()).AddAccount(acc)
 		} else {
 			return err
 		}
@@ -237,9 +292,176 @@ func tryAddAccount(p core.Provider, pr core.Prompter) error {
 func useAccounts(d *core.Client) {
 	for _, provider := range core.RegisteredProviders() {
 		if ac, ok := provider.(core.Accountant); ok {
-			for _, acc := range app.AccountManagerFor("", ac).Accounts() {
+			for _, acc := range app.AccountManagerWith("", ac, logger()).Accounts() {
 				d.Use(acc)
 			}
 		}
 	}
 }
+
+// logger routes the messages of the client, the accounts and the server to the standard logger,
+// which RunApp sets up as the flags say.
+func logger() core.Logger {
+	return logrusLogger{logrus.NewEntry(logrus.StandardLogger())}
+}
+
+// logrusLogger adapts a logrus entry to core.Logger
+type logrusLogger struct{ *logrus.Entry }
+
+func (l logrusLogger) WithFields(fields core.Fields) core.Logger {
+	return logrusLogger{l.Entry.WithFields(logrus.Fields(fields))}
+}
+
+// useHTTP sets up the middleware of the client's and the providers' HTTP requests.
+func useHTTP(d *core.Client, agent string, perSecond float64) {
+	if agent == "" {
+		agent = "uget/" + core.Version
+	}
+	d.Middleware = append(d.Middleware, core.Logging(logger()), core.UserAgent(agent))
+	if perSecond > 0 {
+		d.Middleware = append(d.Middleware, core.RateLimiting(perSecond))
+	}
+}
+
+// useProviderConfig orders and adjusts the providers of the client as the user configured them.
+func useProviderConfig(d *core.Client) error {
+	cfg, err := app.LoadProviderConfig("")
+	if err != nil {
+		return fmt.Errorf("could not read the provider config: %v", err)
+	}
+	d.UseProviderConfig(cfg)
+	return nil
+}
+
+// useRewrites adds the rewrite rules given on the command line to the client.
+func useRewrites(d *core.Client, specs []string) error {
+	for _, spec := range specs {
+		rule, err := core.ParseRewriteRule(spec)
+		if err != nil {
+			return err
+		}
+		d.Rewrites = append(d.Rewrites, rule)
+	}
+	return nil
+}
+
+// capabilities lists what the provider is able to do.
+func capabilities(p core.Provider) []string {
+	var caps []string
+	switch p.(type) {
+	case core.SingleResolver, core.MultiResolver, core.ContextSingleResolver, core.ContextMultiResolver:
+		caps = append(caps, "resolve")
+	}
+	switch p.(type) {
+	case core.Retriever, core.ContextRetriever:
+		caps = append(caps, "retrieve")
+	}
+	if _, ok := p.(core.Accountant); ok {
+		caps = append(caps, "accounts")
+	}
+	return caps
+}
+
+// daemonArgs returns the arguments of the server the daemon runs, i.e. the global and the daemon's options
+// as they were parsed, followed by the remaining arguments.
+func daemonArgs(args []string, opts *options) []string {
+	daemon := []string{"--log-level", opts.LogLevel, "--log-format", opts.LogFormat}
+	if opts.LogFile != "" {
+		daemon = append(daemon, "--log-file", opts.LogFile)
+	}
+	s := opts.Daemon.server
+	daemon = append(daemon, "server", "--port", strconv.Itoa(int(s.Port)))
+	if s.BindAddr != "" {
+		daemon = append(daemon, "--bind", s.BindAddr)
+	}
+	for _, w := range s.Windows {
+		daemon = append(daemon, "--window", w)
+	}
+	if s.Bandwidth != "" {
+		daemon = append(daemon, "--bandwidth", s.Bandwidth)
+	}
+	if s.AutoJobs != "" {
+		daemon = append(daemon, "--auto-jobs", s.AutoJobs)
+	}
+	return append(daemon, args...)
+}
+
+// parseAutoscale parses the bounds of --auto-jobs, e.g. `1-8`.
+func parseAutoscale(spec string) (*core.Autoscale, error) {
+	bounds := strings.Split(spec, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid bounds %q, expected MIN-MAX", spec)
+	}
+	min, err1 := strconv.Atoi(bounds[0])
+	max, err2 := strconv.Atoi(bounds[1])
+	if err1 != nil || err2 != nil || min < 1 || max < min {
+		return nil, fmt.Errorf("invalid bounds %q, expected MIN-MAX", spec)
+	}
+	return &core.Autoscale{Min: min, Max: max}, nil
+}
+
+// parseMinSpeed parses the floor of --min-speed, e.g. `10k/30s`.
+func parseMinSpeed(spec string) (int64, time.Duration, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid speed %q, expected SIZE/DURATION", spec)
+	}
+	size, err := units.RAMInBytes(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid speed %q: %v", spec, err)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid speed %q: %v", spec, err)
+	}
+	return size, period, nil
+}
+
+// readsStdin returns whether grabURLs reads the links from stdin.
+func readsStdin(args []string, opts *urlArgs) bool {
+	if opts.Inline {
+		return false
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			return true
+		}
+	}
+	return len(args) == 0
+}
+
+// describe returns the error message, prefixed with the error's kind if it is known.
+func describe(err error) string {
+	if kind := api.KindOf(err); kind != api.Unknown {
+		return fmt.Sprintf("%v: %v", kind, err)
+	}
+	return err.Error()
+}
+
+// unsupported returns whether the error means that no provider supports the URL.
+func unsupported(err error) bool {
+	return err != nil && api.KindOf(err) == api.Unsupported
+}
+
+// parseJobs interprets a line typed during `uget get`: `+` and `-` add or remove a job,
+// a number sets the amount of jobs.
+func parseJobs(line string, jobs int) (int, bool) {
+	switch line = strings.TrimSpace(line); line {
+	case "+":
+		return jobs + 1, true
+	case "-":
+		return jobs - 1, jobs > 1
+	}
+	n, err := strconv.Atoi(line)
+	return n, err == nil && n > 0
+}
+
+// controlJobs lets the user change the amount of parallel downloads by typing into stdin.
+func controlJobs(client *core.Client) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if jobs, ok := parseJobs(scanner.Text(), client.Concurrency()); ok {
+			client.SetConcurrency(jobs)
+		}
+	}
+}
@@ -16,3 +16,69 @@ func TestPrettyTime(t *testing.T) {
 	assert.Equal(t, "4y", prettyTime(4*365*24*time.Hour))
 	assert.Equal(t, "55y10d", prettyTime(55*365*24*time.Hour+240*time.Hour))
 }
+
+func TestParseJobs(t *testing.T) {
+	jobs, ok := parseJobs("+", 3)
+	assert.True(t, ok)
+	assert.Equal(t, 4, jobs)
+	jobs, ok = parseJobs(" - ", 3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, jobs)
+	_, ok = parseJobs("-", 1)
+	assert.False(t, ok)
+	jobs, ok = parseJobs("8\n", 3)
+	assert.True(t, ok)
+	assert.Equal(t, 8, jobs)
+	for _, line := range []string{"", "0", "-2", "x"} {
+		_, ok = parseJobs(line, 3)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestParseAutoscale(t *testing.T) {
+	a, err := parseAutoscale("2-8")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Min)
+	assert.Equal(t, 8, a.Max)
+	for _, spec := range []string{"", "4", "0-3", "5-2", "a-b"} {
+		_, err = parseAutoscale(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseMinSpeed(t *testing.T) {
+	speed, period, err := parseMinSpeed("10k/30s")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10*1024), speed)
+	assert.Equal(t, 30*time.Second, period)
+	for _, spec := range []string{"", "10k", "x/30s", "10k/x"} {
+		_, _, err = parseMinSpeed(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseChoice(t *testing.T) {
+	choice, err := parseChoice([]string{"via=premium", "resolve-via=folders", "exclude=free,other"})
+	assert.NoError(t, err)
+	assert.Equal(t, "premium", choice.Retrieve)
+	assert.Equal(t, "folders", choice.Resolve)
+	assert.Equal(t, []string{"free", "other"}, choice.Exclude)
+	for _, field := range []string{"via", "via=", "over=premium"} {
+		_, err = parseChoice([]string{field})
+		assert.Error(t, err, field)
+	}
+}
+
+func TestDaemonArgs(t *testing.T) {
+	// uget --log-level debug daemon -p 1234 -w '* 07:00-23:00 pause' --auto-jobs 1-4
+	opts := &options{LogLevel: "debug", LogFormat: "text"}
+	opts.Daemon.Port = 1234
+	opts.Daemon.Windows = []string{"* 07:00-23:00 pause"}
+	opts.Daemon.AutoJobs = "1-4"
+	assert.Equal(t, []string{
+		"--log-level", "debug", "--log-format", "text",
+		"server", "--port", "1234", "--window", "* 07:00-23:00 pause", "--auto-jobs", "1-4",
+	}, daemonArgs(nil, opts))
+	opts.LogFile = "uget.log"
+	assert.Equal(t, []string{"uget.log", "server"}, daemonArgs(nil, opts)[5:7])
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"github.com/uget/uget/core/api"
+)
+
+// DefaultMaxDepth limits the chains of Yields and Bundles if Client.MaxDepth is 0
+const DefaultMaxDepth = 32
+
+// DefaultMaxFanOut limits the requests bundled within a container if Client.MaxFanOut is 0
+const DefaultMaxFanOut = 100000
+
+var (
+	// ErrCycle is the error of a request that leads back to the URL of one of its ancestors,
+	// e.g. a folder that contains itself.
+	ErrCycle = api.Errorf(api.Unsupported, "link leads back to itself")
+	// ErrTooDeep is the error of a request nested deeper than Client.MaxDepth.
+	ErrTooDeep = api.Errorf(api.Unsupported, "links nested too deeply")
+	// ErrFanOut is the error of a request bundled after its container reached Client.MaxFanOut.
+	ErrFanOut = api.Errorf(api.Unsupported, "container bundles too many links")
+)
+
+// unfollowable returns why the unresolved request must not be resolved, nil if it may be.
+func (d *Client) unfollowable(r *request) error {
+	maxFanOut := d.MaxFanOut
+	if maxFanOut <= 0 {
+		maxFanOut = DefaultMaxFanOut
+	}
+	if r.bundled > maxFanOut {
+		return ErrFanOut
+	}
+	maxDepth := d.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if r.depth() > maxDepth {
+		return ErrTooDeep
+	}
+	u := r.u.String()
+	for a := r.parent; a != nil; a = a.parent {
+		if a.u.String() == u {
+			return ErrCycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hostURL(path string) *url.URL {
+	return &url.URL{Scheme: "http", Host: "host", Path: path}
+}
+
+func testRequest(path string) *request {
+	u := hostURL(path)
+	return rootRequest(u, newContainer(context.Background(), []*url.URL{u}, ContainerOptions{}), 0)
+}
+
+func TestUnfollowableCycle(t *testing.T) {
+	d := NewClient()
+	root := testRequest("/folder")
+	sub := root.Yields(hostURL("/sub")).(*request)
+	assert.NoError(t, d.unfollowable(sub))
+	back := sub.Yields(hostURL("/folder")).(*request)
+	assert.Equal(t, ErrCycle, d.unfollowable(back))
+}
+
+func TestUnfollowableDepth(t *testing.T) {
+	d := NewClient()
+	d.MaxDepth = 3
+	r := testRequest("/0")
+	for i := 1; i <= 3; i++ {
+		r = r.Yields(hostURL(fmt.Sprintf("/%d", i))).(*request)
+		assert.NoError(t, d.unfollowable(r))
+	}
+	r = r.Yields(hostURL("/4")).(*request)
+	assert.Equal(t, ErrTooDeep, d.unfollowable(r))
+}
+
+func TestUnfollowableFanOut(t *testing.T) {
+	d := NewClient()
+	d.MaxFanOut = 3
+	children := testRequest("/folder").Bundles([]*url.URL{hostURL("/a"), hostURL("/b"), hostURL("/c"), hostURL("/d")})
+	for _, child := range children[:3] {
+		assert.NoError(t, d.unfollowable(child.(*request)))
+	}
+	assert.Equal(t, ErrFanOut, d.unfollowable(children[3].(*request)))
+}
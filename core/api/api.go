@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"hash"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // FileSizeUnknown (returned by File#Size) denotes a file's size is unknown
@@ -14,6 +17,21 @@ const FileSizeUnknown = -1
 // ErrTODO is a special error type for unimplemented placeholder procedures
 var ErrTODO = errors.New("not implemented")
 
+// WaitError is returned by providers that cannot serve a request right now,
+// e.g. free tiers that impose a waiting time. The client tries again after Wait
+// without occupying a worker, other files proceed meanwhile.
+type WaitError struct {
+	Wait   time.Duration
+	Reason string // optional, e.g. "download limit reached"
+}
+
+func (e *WaitError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("wait %v", e.Wait)
+	}
+	return fmt.Sprintf("%s, wait %v", e.Reason, e.Wait)
+}
+
 // Account represents a persistent record on a provider (useful e.g. to access restricted files)
 type Account interface {
 	// Returns a unique identifier for this account.
@@ -32,6 +50,15 @@ type File interface {
 	Provider() Provider
 }
 
+// Expiring is a File whose URL is only valid for a limited time, e.g. a generated premium link.
+// Files that expired before being retrieved are resolved again.
+type Expiring interface {
+	File
+
+	// Expires returns the time the URL stops working. The zero Time means never.
+	Expires() time.Time
+}
+
 // Prompter asks for user input
 type Prompter interface {
 	Get(f []Field) (map[string]string, error)
@@ -55,6 +82,10 @@ type Provider interface {
 // Config object
 type Config struct {
 	Accounts []Account
+
+	// HTTP is managed by the client: it applies the configured transport, proxy, cookies, user agent,
+	// timeout and middleware (e.g. logging, rate limiting, retries). Providers should send all their requests with it.
+	HTTP *http.Client
 }
 
 // Configured are providers that require some kind of configuration/initialization
@@ -74,6 +105,11 @@ type Request interface {
 	// Returns the URL of this request.
 	URL() *url.URL
 
+	// Context returns the context of this request. It is done when the request's container
+	// is canceled or the client is stopped. Within ResolveOne and ResolveMany, it is the context of the call:
+	// it is also done when the call timed out, and in batches only once the containers of all requests are canceled.
+	Context() context.Context
+
 	// Wrap wraps this Request in a singleton slice. It is a helper for SingleResolvers.
 	Wrap() []Request
 
@@ -133,6 +169,52 @@ type SingleResolver interface {
 	ResolveOne(Request) ([]Request, error)
 }
 
+// ContextSingleResolver is a SingleResolver whose calls can be aborted through the context.
+type ContextSingleResolver interface {
+	resolver
+
+	ResolveOneContext(context.Context, Request) ([]Request, error)
+}
+
+// ContextMultiResolver is a MultiResolver whose calls can be aborted through the context.
+type ContextMultiResolver interface {
+	resolver
+
+	ResolveManyContext(context.Context, []Request) ([]Request, error)
+}
+
+// ContextSingle adapts a SingleResolver to ContextSingleResolver.
+// The call itself is not aborted, the resolver may observe Request.Context for that.
+// Its results are discarded if the context is done by the time it returns.
+func ContextSingle(r SingleResolver) ContextSingleResolver {
+	return contextSingle{r}
+}
+
+type contextSingle struct{ SingleResolver }
+
+func (r contextSingle) ResolveOneContext(ctx context.Context, req Request) ([]Request, error) {
+	reqs, err := r.ResolveOne(req)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reqs, err
+}
+
+// ContextMulti adapts a MultiResolver to ContextMultiResolver, like ContextSingle.
+func ContextMulti(r MultiResolver) ContextMultiResolver {
+	return contextMulti{r}
+}
+
+type contextMulti struct{ MultiResolver }
+
+func (r contextMulti) ResolveManyContext(ctx context.Context, reqs []Request) ([]Request, error) {
+	results, err := r.ResolveMany(reqs)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return results, err
+}
+
 // Retriever is a provider which can download specific URLs
 type Retriever interface {
 	Provider
@@ -151,6 +233,76 @@ type Retriever interface {
 	CanRetrieve(File) uint
 }
 
+// Limits restrict how hard the client may use a provider. Zero values mean unlimited.
+type Limits struct {
+	Concurrency       int     `json:"concurrency,omitempty"`         // parallel resolver calls
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"` // resolver calls per second
+	BatchSize         int     `json:"batch_size,omitempty"`          // requests per ResolveMany call
+}
+
+// Throttled is a provider that declares its Limits
+type Throttled interface {
+	Provider
+
+	Limits() Limits
+}
+
+// ContextRetriever is a Retriever whose Retrieve calls can be aborted through the context,
+// e.g. when they need to request a download link first.
+type ContextRetriever interface {
+	Provider
+
+	RetrieveContext(context.Context, File) (*http.Request, error)
+
+	// See Retriever
+	CanRetrieve(File) uint
+}
+
+// ContextRetrieve adapts a Retriever to ContextRetriever, like ContextSingle.
+func ContextRetrieve(r Retriever) ContextRetriever {
+	return contextRetriever{r}
+}
+
+type contextRetriever struct{ Retriever }
+
+func (r contextRetriever) RetrieveContext(ctx context.Context, f File) (*http.Request, error) {
+	req, err := r.Retrieve(f)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return req, err
+}
+
+// Hosted is a provider that only handles URLs of certain hosts. The client consults it only for those,
+// instead of asking every provider about every URL.
+// A hosted provider is still asked to retrieve the files it resolved itself, whatever their host.
+type Hosted interface {
+	Provider
+
+	// Hosts returns the host names this provider handles. `*.example.com` matches all subdomains of example.com.
+	Hosts() []string
+}
+
+// Canonicalizer is a provider that knows several forms of the URLs it handles, e.g. mirrors or short links.
+// The client canonicalizes every URL before it is resolved, so that duplicates collapse.
+type Canonicalizer interface {
+	Provider
+
+	// Canonicalize returns the canonical form of the URL, nil if this provider does not recognize it.
+	Canonicalize(*url.URL) *url.URL
+}
+
+// Accounted is a Retriever that uses one of several accounts, and tells which.
+// The client records the statistics of each account on its own.
+// If an account fails with QuotaExceeded or AuthFailed, the client retries the provider only once
+// AccountFor returns another account for the file.
+type Accounted interface {
+	Provider
+
+	// AccountFor returns the account the file would be retrieved with, nil if none.
+	AccountFor(File) Account
+}
+
 // Accountant is a provider that stores user accounts
 type Accountant interface {
 	Provider
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies the errors of providers, so the client knows how to react to them.
+type ErrorKind int
+
+const (
+	// Unknown - the error is not classified. The client retries, if its RetryPolicy allows.
+	Unknown ErrorKind = iota
+	// NotFound - the file does not exist (anymore). The client treats it as offline.
+	NotFound
+	// Unavailable - the provider is temporarily down. The client retries.
+	Unavailable
+	// QuotaExceeded - the account's traffic or download limit is exhausted.
+	// The client switches to another provider that can retrieve the file, if there is one.
+	QuotaExceeded
+	// AuthFailed - the credentials were rejected. The client switches like for QuotaExceeded.
+	AuthFailed
+	// RateLimited - too many requests. The client retries; see WaitError for a known wait.
+	RateLimited
+	// Unsupported - the provider cannot handle the URL or file. The client does not retry.
+	Unsupported
+)
+
+var errorKinds = [...]string{"unknown", "not found", "unavailable", "quota exceeded", "auth failed", "rate limited", "unsupported"}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(errorKinds) {
+		return fmt.Sprintf("ErrorKind(%d)", k)
+	}
+	return errorKinds[k]
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (k ErrorKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Error is an error of a known kind. Providers return it to tell the client how to proceed.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error, for errors.Is and errors.As
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap classifies err as the given kind. Returns nil if err is nil.
+func Wrap(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind, err}
+}
+
+// Errorf formats an error of the given kind.
+func Errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{kind, fmt.Errorf(format, args...)}
+}
+
+// KindOf returns the kind of err. Errors are unwrapped through their `Cause() error` or `Unwrap() error` method,
+// and errors can classify themselves with a `Kind() ErrorKind` method.
+func KindOf(err error) ErrorKind {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			return e.Kind
+		case *WaitError:
+			return RateLimited
+		case interface {
+			Kind() ErrorKind
+		}:
+			return e.Kind()
+		case interface {
+			Cause() error
+		}:
+			err = e.Cause()
+		default:
+			err = errors.Unwrap(err)
+		}
+	}
+	return Unknown
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cause struct{ err error }
+
+func (c cause) Error() string { return "wrapped: " + c.err.Error() }
+func (c cause) Cause() error  { return c.err }
+
+func TestKindOf(t *testing.T) {
+	base := errors.New("gone")
+	assert.Equal(t, Unknown, KindOf(nil))
+	assert.Equal(t, Unknown, KindOf(base))
+	assert.Equal(t, NotFound, KindOf(Wrap(NotFound, base)))
+	assert.Equal(t, QuotaExceeded, KindOf(cause{Errorf(QuotaExceeded, "%d GB left", 0)}))
+	assert.Equal(t, RateLimited, KindOf(cause{&WaitError{}}))
+	assert.Equal(t, Unknown, KindOf(cause{fmt.Errorf("plain")}))
+	wrapped := fmt.Errorf("retrieving: %w", Wrap(NotFound, base))
+	assert.Equal(t, NotFound, KindOf(wrapped))
+	assert.True(t, errors.Is(wrapped, base))
+	var apiErr *Error
+	assert.True(t, errors.As(wrapped, &apiErr))
+	assert.Nil(t, Wrap(AuthFailed, nil))
+	assert.Equal(t, "gone", Wrap(NotFound, base).Error())
+	assert.Equal(t, "quota exceeded", QuotaExceeded.String())
+	assert.Equal(t, "ErrorKind(42)", ErrorKind(42).String())
+	assert.Equal(t, "ErrorKind(-1)", ErrorKind(-1).String())
+}
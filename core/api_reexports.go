@@ -39,8 +39,26 @@ type MultiResolver = api.MultiResolver
 // SingleResolver is a provider which can only resolve URLs one by one
 type SingleResolver = api.SingleResolver
 
+// ContextMultiResolver is a MultiResolver whose calls can be aborted through the context
+type ContextMultiResolver = api.ContextMultiResolver
+
+// ContextSingleResolver is a SingleResolver whose calls can be aborted through the context
+type ContextSingleResolver = api.ContextSingleResolver
+
 // Retriever is a provider which can download specific URLs
 type Retriever = api.Retriever
 
+// ContextRetriever is a Retriever whose calls can be aborted through the context
+type ContextRetriever = api.ContextRetriever
+
+// Hosted is a provider that only handles URLs of certain hosts
+type Hosted = api.Hosted
+
+// Canonicalizer is a provider that knows several forms of the URLs it handles
+type Canonicalizer = api.Canonicalizer
+
+// Accounted is a Retriever that tells which of its accounts it uses
+type Accounted = api.Accounted
+
 // Accountant is a provider that stores user accounts
 type Accountant = api.Accountant
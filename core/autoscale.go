@@ -0,0 +1,122 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/uget/uget/utils/rate"
+)
+
+// Autoscale adjusts the concurrency of a Client to the measured throughput.
+// Workers are added while the combined speed of all downloads rises, and removed
+// again when it plateaus, drops or downloads start failing.
+//
+// Setting the concurrency manually takes precedence: once Client.SetConcurrency is called
+// after Start, autoscaling ends and the client keeps the concurrency it was given.
+// Before Start, SetConcurrency only sets where autoscaling begins, within Min and Max.
+type Autoscale struct {
+	Min       int           `json:"min"`
+	Max       int           `json:"max"`
+	Interval  time.Duration `json:"interval,omitempty"`  // between adjustments, 10 seconds if 0
+	Threshold float64       `json:"threshold,omitempty"` // relative change in speed that counts, 0.1 if 0
+}
+
+// scaler is the state of the hill climbing performed by Client#autoscale.
+type scaler struct {
+	Autoscale
+	last float64 // speed of the previous interval
+	dir  int     // direction of the last change: 1 grown, -1 shrunk, 0 holding
+	held int     // intervals spent holding
+}
+
+// idle is the number of intervals spent holding before probing for more throughput again.
+const idle = 6
+
+// next returns the concurrency for the interval to come.
+// saturated tells whether all workers were busy, i.e. whether more of them would have had work.
+func (s *scaler) next(n int, speed float64, failed, saturated bool) int {
+	defer func() { s.last = speed }()
+	switch {
+	case failed:
+		s.dir = -1
+	case s.last == 0 || speed > s.last*(1+s.Threshold):
+		// the last change paid off, keep going. From holding, probe for more.
+		if s.dir == 0 {
+			s.dir = 1
+		}
+	case speed < s.last*(1-s.Threshold):
+		if s.dir == 0 {
+			s.dir = 1
+		} else {
+			s.dir = -s.dir
+		}
+	case s.dir > 0:
+		// the last worker did not bring anything, so take it back and hold.
+		s.dir, s.held = 0, 0
+		return s.clamp(n - 1)
+	default:
+		// holding. Probe for more now and then, the circumstances may have changed.
+		s.dir = 0
+		if s.held++; s.held >= idle {
+			s.dir, s.held = 1, 0
+		}
+	}
+	if s.dir > 0 && !saturated {
+		s.dir = 0
+	}
+	return s.clamp(n + s.dir)
+}
+
+func (s *scaler) clamp(n int) int {
+	if n < s.Min {
+		return s.Min
+	} else if n > s.Max {
+		return s.Max
+	}
+	return n
+}
+
+// autoscale adjusts the concurrency until the client is stopped.
+func (d *Client) autoscale(a Autoscale) {
+	if a.Min < 1 {
+		a.Min = 1
+	}
+	if a.Max < a.Min {
+		a.Max = a.Min
+	}
+	if a.Interval == 0 {
+		a.Interval = 10 * time.Second
+	}
+	if a.Threshold == 0 {
+		a.Threshold = 0.1
+	}
+	s := &scaler{Autoscale: a}
+	if !d.scale(s.clamp(d.Concurrency())) {
+		return
+	}
+	rater := rate.SmoothRate(3)
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	var transferred, failures int64
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+		t, f := atomic.LoadInt64(&d.transferred), atomic.LoadInt64(&d.failures)
+		rater.Add(t - transferred)
+		speed := rater.Rate().Float()
+		failed := f > failures
+		transferred, failures = t, f
+		n := d.Concurrency()
+		saturated := int(atomic.LoadInt32(&d.busy)) >= n
+		if next := s.next(n, speed, failed, saturated); next != n {
+			if !d.scale(next) {
+				d.logger().Infof("Client#autoscale: concurrency set manually, no longer autoscaling")
+				return
+			}
+			d.logger().Infof("Client#autoscale: %.0f B/s, %v -> %v workers", speed, n, next)
+		}
+	}
+}
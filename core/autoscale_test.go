@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalerNext(t *testing.T) {
+	s := &scaler{Autoscale: Autoscale{Min: 1, Max: 4, Threshold: 0.1}}
+	// grows while the speed rises
+	assert.Equal(t, 2, s.next(1, 100, false, true))
+	assert.Equal(t, 3, s.next(2, 200, false, true))
+	// the third worker did not help, take it back
+	assert.Equal(t, 2, s.next(3, 205, false, true))
+	// holds, then probes again
+	for i := 1; i < idle; i++ {
+		assert.Equal(t, 2, s.next(2, 200, false, true))
+	}
+	assert.Equal(t, 3, s.next(2, 200, false, true))
+	// backs off on failures
+	assert.Equal(t, 2, s.next(3, 300, true, true))
+	// keeps shrinking while that raises the speed
+	assert.Equal(t, 1, s.next(2, 400, false, true))
+	// does not grow without work, and stays within bounds
+	s = &scaler{Autoscale: Autoscale{Min: 1, Max: 2, Threshold: 0.1}}
+	assert.Equal(t, 1, s.next(1, 100, false, false))
+	assert.Equal(t, 2, s.next(2, 100, false, true))
+	assert.Equal(t, 1, s.next(1, 100, true, true))
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net/url"
+
+	"github.com/uget/uget/core/api"
+)
+
+// ProviderChoice restricts the providers that handle links.
+// The provider forced to resolve a link only resolves the link itself, not the links it leads to.
+type ProviderChoice struct {
+	Resolve  string   `json:"resolve,omitempty"`  // name of the provider that must resolve the link, any if empty
+	Retrieve string   `json:"retrieve,omitempty"` // name of the provider that must retrieve the files, any if empty
+	Exclude  []string `json:"exclude,omitempty"`  // names of providers that must not be used at all
+}
+
+// choice returns the ProviderChoice for the files of the given link of the container.
+func (o ContainerOptions) choice(u *url.URL) ProviderChoice {
+	choice := o.Providers
+	if override, ok := o.Overrides[u.String()]; ok {
+		if override.Resolve != "" {
+			choice.Resolve = override.Resolve
+		}
+		if override.Retrieve != "" {
+			choice.Retrieve = override.Retrieve
+		}
+		choice.Exclude = append(choice.Exclude[:len(choice.Exclude):len(choice.Exclude)], override.Exclude...)
+	}
+	return choice
+}
+
+func (c ProviderChoice) excludes(p Provider) bool {
+	for _, name := range c.Exclude {
+		if p.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolversFor returns the providers that may resolve the request.
+func (d *Client) resolversFor(r *request) Providers {
+	choice := r.container.opts.choice(r.root().u)
+	if choice.Resolve != "" && r.parent == nil {
+		if p := d.Providers.GetProvider(choice.Resolve); p != nil && !choice.excludes(p) {
+			return Providers{p}
+		}
+		return nil
+	}
+	return choice.allowed(d.index().lookup(r.u, nil))
+}
+
+// retrieversFor returns the providers that may retrieve the file.
+func (d *Client) retrieversFor(file File) Providers {
+	choice := file.request().container.opts.choice(file.CanonicalURL())
+	if choice.Retrieve != "" {
+		if p := d.Providers.GetProvider(choice.Retrieve); p != nil && !choice.excludes(p) {
+			return Providers{p}
+		}
+		return nil
+	}
+	return choice.allowed(d.index().lookup(file.URL(), file.Provider()))
+}
+
+// allowed returns the providers that are not excluded.
+func (c ProviderChoice) allowed(candidates Providers) Providers {
+	if len(c.Exclude) == 0 {
+		return candidates
+	}
+	ps := make(Providers, 0, len(candidates))
+	for _, p := range candidates {
+		if !c.excludes(p) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// noResolver returns the error of a request that no (allowed) provider can resolve.
+func (d *Client) noResolver(r *request) error {
+	choice := r.container.opts.choice(r.root().u)
+	if choice.Resolve != "" && r.parent == nil {
+		return forcedError(d.Providers, choice, choice.Resolve, "resolve", r.u)
+	}
+	return api.Errorf(api.Unsupported, "no provider supports %v", r.u)
+}
+
+// noRetriever returns the error of a file that no (allowed) provider can retrieve.
+func (d *Client) noRetriever(file File) error {
+	choice := file.request().container.opts.choice(file.CanonicalURL())
+	if choice.Retrieve != "" {
+		return forcedError(d.Providers, choice, choice.Retrieve, "retrieve", file.URL())
+	}
+	return api.Errorf(api.Unsupported, "no provider can retrieve %v", file.URL())
+}
+
+func forcedError(ps Providers, choice ProviderChoice, name, action string, u *url.URL) error {
+	p := ps.GetProvider(name)
+	switch {
+	case p == nil:
+		return api.Errorf(api.Unsupported, "provider %s, forced to %s %v, does not exist", name, action, u)
+	case choice.excludes(p):
+		return api.Errorf(api.Unsupported, "provider %s, forced to %s %v, is excluded", name, action, u)
+	}
+	return api.Errorf(api.Unsupported, "provider %s, forced to %s %v, cannot %s it", name, action, u, action)
+}
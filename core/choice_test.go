@@ -0,0 +1,20 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerOptionsChoice(t *testing.T) {
+	opts := ContainerOptions{
+		Providers: ProviderChoice{Retrieve: "premium", Exclude: []string{"free"}},
+		Overrides: map[string]ProviderChoice{
+			"http://host/a": {Resolve: "folders", Exclude: []string{"other"}},
+		},
+	}
+	assert.Equal(t, opts.Providers, opts.choice(hostURL("/b")))
+	assert.Equal(t, ProviderChoice{Resolve: "folders", Retrieve: "premium", Exclude: []string{"free", "other"}}, opts.choice(hostURL("/a")))
+	// the container's choice stays intact
+	assert.Equal(t, []string{"free"}, opts.Providers.Exclude)
+}
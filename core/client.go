@@ -12,20 +12,10 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/Sirupsen/logrus"
-	"github.com/chuckpreslar/emission"
 	"github.com/uget/uget/core/api"
-)
-
-type event int
-
-const (
-	eDownload event = iota
-	eError
-	eResolve
-	eDeadend
-	eSkip
+	"github.com/uget/uget/utils/rate"
 )
 
 // Client manages downloads
@@ -35,12 +25,56 @@ type Client struct {
 	NoContinue    bool
 	Providers     Providers
 	Accounts      map[string][]Account
+	Schedule      SchedulePolicy        // order of retrieval, PriorityOrder if nil. Applied on Start.
+	Retry         RetryPolicy           // default for containers without their own RetryPolicy
+	Session       *Session              // journals the containers, if set. See Restore.
+	Timetable     Timetable             // pauses and limits the retrievers by time of day. Applied on Start.
+	Bandwidth     int64                 // limit in bytes per second outside of the Timetable, 0 means unlimited
+	Autoscale     *Autoscale            // adjusts the concurrency to the throughput, if set. Applied on Start.
+	Watchdog      Watchdog              // detects stalled downloads, disabled if zero
+	Resolvers     int                   // maximum of parallel resolver calls, 0 means DefaultResolvers
+	Limits        map[string]api.Limits // by provider name, override the limits providers declare
+	Bias          map[string]int        // by provider name, added to the priorities of CanRetrieve. See UseProviderConfig.
+	Stats         *Stats                // records how providers perform and ranks the retrievers by it, if set
+	Log           Logger                // receives the messages of the client, they are dropped if nil
+	Rewrites      []RewriteRule         // applied to links before they are resolved, in order
+	Timeout       time.Duration         // of a single call to a provider, 0 means none
+	MaxDepth      int                   // of chains of Yields and Bundles, 0 means DefaultMaxDepth
+	MaxFanOut     int                   // requests the links of a container may bundle, 0 means DefaultMaxFanOut
+	MaxWaits      int                   // WaitErrors honored per file before it fails, 0 means DefaultMaxWaits
+	Transport     http.RoundTripper     // of all HTTP requests, http.DefaultTransport if nil. Set before Start.
+	Middleware    []Middleware          // wrap the Transport, the first one outermost. Set before Start.
+	Jar           http.CookieJar        // of all HTTP requests, no cookies are kept if nil. Set before Start.
 	ResolvedQueue *queue
-	httpClient    *http.Client
+	httpClient    *http.Client // of the downloads
+	chain         http.RoundTripper
+	httpMtx       sync.Mutex
 	resolverQueue *queue
 	retrievers    int // number of retriever/downloader jobs
 	dryrun        bool
-	emitter       *emission.Emitter
+	bus           *bus
+	limiter       *rate.Limiter
+	gate          chan struct{} // closed while retrievers may start downloads
+	gateMtx       sync.Mutex
+	paused        bool            // by Pause
+	scheduled     bool            // paused by the Timetable
+	ctx           context.Context // done when the client is stopped
+	cancel        context.CancelFunc
+	poolMtx       sync.Mutex
+	concurrency   int           // target number of retriever jobs
+	workers       int           // number of running retriever jobs
+	resized       chan struct{} // closed when the concurrency changes
+	started       bool
+	manual        bool  // the concurrency was set after Start, which ends autoscaling
+	transferred   int64 // bytes downloaded, for autoscaling
+	failures      int64 // failed download attempts, for autoscaling
+	busy          int32 // number of retrievers downloading
+	throttles     map[Provider]*throttle
+	throttlesMtx  sync.Mutex
+	resolving     chan struct{} // slots of the resolver pool
+	inflight      chan struct{} // slots of the resolve units in flight
+	idx           *providerIndex
+	indexMtx      sync.Mutex
 }
 
 // NewClient creates a new Client with 3 retrievers and 1 resolver
@@ -51,13 +85,22 @@ func NewClient() *Client {
 // NewClientWith creates a new Client with the amount of workers provided.
 // If amount is 0, the Client works in resolve-only mode.
 func NewClientWith(retrievers int) *Client {
+	gate := make(chan struct{})
+	close(gate)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		emitter:       emission.NewEmitter(),
+		bus:           new(bus),
+		limiter:       rate.NewLimiter(0),
+		gate:          gate,
+		ctx:           ctx,
+		cancel:        cancel,
 		Providers:     RegisteredProviders(),
-		resolverQueue: newQueue(),
+		resolverQueue: newBatchQueue(resolveBatch),
+		inflight:      make(chan struct{}, resolveBatch),
 		ResolvedQueue: newQueue(),
 		retrievers:    retrievers,
-		httpClient:    new(http.Client),
+		concurrency:   retrievers,
+		resized:       make(chan struct{}),
 		Accounts:      make(map[string][]Account),
 	}
 }
@@ -65,11 +108,48 @@ func NewClientWith(retrievers int) *Client {
 // AddURLs adds a list of URLs to the download queue.
 // Returns a WaitGroup for when the downloads are complete.
 func (d *Client) AddURLs(urls []*url.URL) Container {
-	wg := new(sync.WaitGroup)
-	container := &container{id: ContainerID(urls), wg: wg}
-	wg.Add(len(urls) + 1)
+	return d.AddURLsWith(urls, ContainerOptions{})
+}
+
+// AddURLsWith adds a list of URLs to the download queue as a container with the given options.
+func (d *Client) AddURLsWith(urls []*url.URL, opts ContainerOptions) Container {
+	if d.Session != nil && opts.Directory == "" {
+		// the working directory might be a different one when the session is restored.
+		if dir, err := filepath.Abs(d.Directory); err == nil {
+			opts.Directory = dir
+		}
+	}
+	urls, originals, opts := d.canonicalize(urls, opts)
+	return d.add(urls, originals, opts, nil, 0)
+}
+
+// add creates a container and enqueues its (canonical) URLs. originals are the URLs as they were passed
+// to the client, the canonical ones if nil.
+// Files with their ID in retrieved are considered done already.
+// journal is the ID of the container in the Session if it is restored, 0 otherwise.
+func (d *Client) add(urls, originals []*url.URL, opts ContainerOptions, retrieved map[string]bool, journal int64) Container {
+	container := newContainer(d.ctx, urls, opts)
+	if originals != nil {
+		container.raw = originals
+	}
+	container.retrieved = retrieved
+	container.journal = journal
+	if d.Session != nil {
+		if err := d.Session.add(container); err != nil {
+			d.logger().Errorf("Session#save: %v", err)
+		}
+	}
+	go func() {
+		<-container.finished
+		if d.Session != nil {
+			if err := d.Session.remove(container); err != nil {
+				d.logger().Errorf("Session#save: %v", err)
+			}
+		}
+		d.emit(containerDone(container))
+	}()
 	go func() {
-		defer wg.Done()
+		defer container.wg.Done()
 		requests := make([]*request, len(urls))
 		for i, u := range urls {
 			requests[i] = rootRequest(u, container, i)
@@ -82,21 +162,164 @@ func (d *Client) AddURLs(urls []*url.URL) Container {
 func (d *Client) configure() {
 	for _, p := range d.Providers {
 		if cfg, ok := p.(Configured); ok {
-			cfg.Configure(&Config{Accounts: d.Accounts[p.Name()]})
+			d.safely(p, "Configure", func() error {
+				cfg.Configure(&Config{Accounts: d.Accounts[p.Name()], HTTP: d.HTTPClient(p)})
+				return nil
+			})
 		}
 	}
 }
 
 // Start starts the Client asynchronously
 func (d *Client) Start() {
-	logrus.Debugf("Client#Start: %v workers", d.retrievers)
+	d.logger().Debugf("Client#Start: %v workers", d.Concurrency())
 	d.configure()
+	if d.Schedule != nil {
+		<-d.ResolvedQueue.schedule(d.Schedule)
+	}
+	d.limiter.SetLimit(d.Bandwidth)
+	if len(d.Timetable) > 0 {
+		go d.follow(d.Timetable)
+	}
+	if d.Autoscale != nil && d.retrievers > 0 {
+		go d.autoscale(*d.Autoscale)
+	}
 	go d.workResolve()
-	for i := 0; i < d.retrievers; i++ {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	d.started = true
+	if d.retrievers > 0 {
+		d.grow()
+	}
+}
+
+// SetConcurrency changes the number of retrievers, i.e. downloads running in parallel.
+// When shrinking, retrievers stop once their current download is done.
+// After Start, it ends autoscaling (see Autoscale).
+// It has no effect on a Client in 'Resolve' mode.
+func (d *Client) SetConcurrency(n int) {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	if d.started {
+		d.manual = true
+	}
+	d.resize(n)
+}
+
+// scale changes the number of retrievers on behalf of autoscaling.
+// It returns false without changing it if the concurrency was set manually.
+func (d *Client) scale(n int) bool {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	if d.manual {
+		return false
+	}
+	d.resize(n)
+	return true
+}
+
+// resize changes the number of retrievers. poolMtx must be held.
+func (d *Client) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if d.retrievers == 0 {
+		return
+	}
+	d.logger().Debugf("Client#resize: %v -> %v workers", d.concurrency, n)
+	d.concurrency = n
+	if !d.started {
+		return
+	}
+	d.grow()
+	close(d.resized)
+	d.resized = make(chan struct{})
+}
+
+// Concurrency returns the number of retrievers.
+func (d *Client) Concurrency() int {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	return d.concurrency
+}
+
+// grow spawns retrievers until there are enough. poolMtx must be held.
+func (d *Client) grow() {
+	for ; d.workers < d.concurrency; d.workers++ {
 		go d.workRetrieve()
 	}
 }
 
+// retire returns whether there are too many retrievers, in which case the calling one has to stop.
+// Otherwise, it returns a channel that is closed on the next resize.
+func (d *Client) retire() (bool, <-chan struct{}) {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	if d.workers > d.concurrency {
+		d.workers--
+		return true, nil
+	}
+	return false, d.resized
+}
+
+// Pause keeps the retrievers from starting new downloads. Running downloads continue.
+// The client stays paused until Resume is called, regardless of the Timetable.
+func (d *Client) Pause() {
+	d.gateMtx.Lock()
+	defer d.gateMtx.Unlock()
+	d.paused = true
+	d.gated()
+}
+
+// Resume lets the retrievers start new downloads again, unless a window of the Timetable pauses them.
+func (d *Client) Resume() {
+	d.gateMtx.Lock()
+	defer d.gateMtx.Unlock()
+	d.paused = false
+	d.gated()
+}
+
+// pauseScheduled pauses or resumes the retrievers on behalf of the Timetable.
+func (d *Client) pauseScheduled(pause bool) {
+	d.gateMtx.Lock()
+	defer d.gateMtx.Unlock()
+	d.scheduled = pause
+	d.gated()
+}
+
+// gated opens or closes the gate, depending on both kinds of pauses. gateMtx must be held.
+func (d *Client) gated() {
+	select {
+	case <-d.gate:
+		if d.paused || d.scheduled {
+			d.gate = make(chan struct{})
+		}
+	default:
+		if !d.paused && !d.scheduled {
+			close(d.gate)
+		}
+	}
+}
+
+// Paused returns whether the retrievers are paused.
+func (d *Client) Paused() bool {
+	d.gateMtx.Lock()
+	defer d.gateMtx.Unlock()
+	select {
+	case <-d.gate:
+		return false
+	default:
+		return true
+	}
+}
+
+// resumed returns a channel that is closed while the retrievers are not paused.
+func (d *Client) resumed() <-chan struct{} {
+	d.gateMtx.Lock()
+	defer d.gateMtx.Unlock()
+	return d.gate
+}
+
 // Use adds an account to this client's repertoire.
 // The account will be passed to Resolvers upon start.
 func (d *Client) Use(acc Account) {
@@ -129,8 +352,14 @@ func (d *Client) Finalize() {
 	d.resolverQueue.Finalize()
 }
 
-// Stop stops this Client immediately
+// Stop stops this Client immediately and writes the statistics that are pending.
 func (d *Client) Stop() {
+	d.cancel()
+	if d.Stats != nil {
+		if err := d.Stats.Flush(); err != nil {
+			d.logger().Errorf("Stats#save: %v", err)
+		}
+	}
 	close(d.ResolvedQueue.get)
 	close(d.ResolvedQueue.getAll)
 	close(d.resolverQueue.get)
@@ -142,41 +371,11 @@ func (d *Client) dryRun(format string, is ...interface{}) bool {
 		fmt.Printf("Would "+format+"\n", is...)
 	} else {
 		capitalized := strings.ToUpper(string(format[0])) + format[1:]
-		logrus.Infof(capitalized, is...)
+		d.logger().Infof(capitalized, is...)
 	}
 	return d.dryrun
 }
 
-// OnDownload calls the given hook when a new Download is started. The download object is passed.
-func (d *Client) OnDownload(f func(*Download)) {
-	d.emitter.On(eDownload, f)
-}
-
-// OnSkip calls the given hook when a download is skipped
-func (d *Client) OnSkip(f func(File)) {
-	d.emitter.On(eSkip, f)
-}
-
-// OnError calls the given hook when an error occurred in `Download`
-func (d *Client) OnError(f func(File, error)) {
-	d.emitter.On(eError, f)
-}
-
-// OnResolve calls the given hook when a resolve job is finished.
-// It passes the original URLs, the File if successful or the error if not.
-func (d *Client) OnResolve(f func(*url.URL, File, error)) {
-	d.emitter.On(eResolve, f)
-}
-
-// OnDeadend calls the given hook when a file is offline.
-func (d *Client) OnDeadend(f func(*url.URL)) {
-	d.emitter.On(eDeadend, f)
-}
-
-func (d *Client) emit(e event, is ...interface{}) {
-	go d.emitter.EmitSync(e, is...)
-}
-
 // === RESOLVE METHODS ===
 
 // Resolve returns meta information on the given URLs
@@ -193,6 +392,10 @@ func Resolve(urls []*url.URL) []File {
 	return files
 }
 
+// resolveBatch limits the requests taken from the resolver queue at once, as well as the resolve units
+// in flight. Only those are bounded: the queue itself holds every request that waits to be resolved.
+const resolveBatch = 1024
+
 func (d *Client) workResolve() {
 	for jobs := range d.resolverQueue.getAll {
 		d.resolve(jobs)
@@ -200,7 +403,15 @@ func (d *Client) workResolve() {
 }
 
 func (d *Client) resolve(jobs []*request) {
-	units := d.units(jobs)
+	live := jobs[:0]
+	for _, job := range jobs {
+		if job.container.isCanceled() {
+			job.container.grow(-1)
+		} else {
+			live = append(live, job)
+		}
+	}
+	units := d.units(live)
 	wg := new(sync.WaitGroup)
 	multis := make(chan *request)
 	wg.Add(len(units))
@@ -216,94 +427,222 @@ func (d *Client) resolve(jobs []*request) {
 		close(multis)
 	}()
 	for _, unit := range units {
+		d.inflight <- struct{}{}
 		go func(unit resolveUnit) {
+			defer func() { <-d.inflight }()
 			defer wg.Done()
-			requests := unit()
+			release := d.throttle(unit.provider)
+			requests := unit.run()
+			release()
 			for _, req := range requests {
 				request := req.(*request)
 				if request.resolved() {
-					if request.file.Err() == nil && request.file.Offline() || d.retrievers == 0 {
-						request.done()
-					} else {
-						d.emit(eResolve, request.u, request.file, nil)
-					}
-					d.ResolvedQueue.enqueue(request)
+					d.resolved(request)
+					continue
+				}
+				request.u = d.canonical(request.u)
+				if err := d.unfollowable(request); err != nil {
+					d.logger().Warnf("Client#resolve: not following %v: %v", request.u, err)
+					d.resolved(request.fails(err))
+				} else if _, ablty := d.resolvability(request); ablty == api.Single {
+					d.resolverQueue.enqueue(request)
 				} else {
-					_, ablty := d.resolvability(request)
-					if ablty == api.Single {
-						d.resolverQueue.enqueue(request)
-					} else {
-						multis <- request
-					}
+					multis <- request
 				}
 			}
 		}(unit)
 	}
 }
 
-type resolveUnit func() []api.Request
+// resolved records the file the request resolved to and passes it on to the retrievers.
+func (d *Client) resolved(r *request) {
+	f, c := r.file, r.container
+	switch {
+	case c.isCanceled():
+		// the resolver may have failed only because the container's context was canceled.
+		c.resolved(f, FileCanceled)
+		d.emit(CancelEvent{info(f), nil})
+		r.done()
+		return
+	case f.Err() != nil:
+		c.resolved(f, FileErrored)
+		d.emit(ResolveEvent{info(f), r.u, f.Err()})
+		d.emit(ErrorEvent{info(f), f.Err()})
+		r.done()
+	case f.Offline():
+		c.resolved(f, FileOffline)
+		d.emit(DeadendEvent{info(f)})
+		r.done()
+	case c.retrieved != nil && c.retrieved[f.ID()]:
+		d.fileLogger(f).Debugf("Client#resolved: %v retrieved in a previous session", f.Name())
+		c.resolved(f, FileDone)
+		d.emit(SkipEvent{info(f)})
+		r.done()
+		return
+	case !c.opts.Filter.Match(f):
+		d.fileLogger(f).Debugf("Client#resolved: %v filtered", f.Name())
+		c.resolved(f, FileSkipped)
+		d.emit(SkipEvent{info(f)})
+		r.done()
+		return
+	default:
+		c.resolved(f, FileQueued)
+		if d.retrievers == 0 {
+			r.done()
+		} else {
+			d.emit(ResolveEvent{info(f), r.u, nil})
+		}
+	}
+	d.ResolvedQueue.enqueue(r)
+}
+
+// resolveUnit is a single call to a resolver
+type resolveUnit struct {
+	provider Provider // nil if no provider supports the requests
+	run      func() []api.Request
+}
 
 // returns: units, retrievable (resolved)
 func (d *Client) units(requests []*request) []resolveUnit {
-	single, multi := d.group(requests)
-	fns := make([]resolveUnit, 0, len(single)+len(multi))
+	single, multi, unsupported := d.group(requests)
+	fns := make([]resolveUnit, 0, len(single)+len(multi)+len(unsupported))
 	for req, resolver := range single {
-		request := req
-		fns = append(fns, func() []api.Request {
-			reqs, err := resolver.ResolveOne(request)
+		request, resolver := req, resolver
+		fns = append(fns, resolveUnit{resolver, func() []api.Request {
+			var reqs []api.Request
+			ctx, cancel := d.callContext(request.container.ctx)
+			request.ctx = ctx
+			err := d.safely(resolver, "ResolveOne", func() (err error) {
+				reqs, err = singleResolver(resolver).ResolveOneContext(ctx, request)
+				return err
+			})
+			cancel()
+			request.ctx = nil
 			if err != nil {
 				if reqs != nil {
-					panic("non-nil request on err!")
+					d.providerLogger(resolver).Errorf("Client#units: %v returned requests along with an error, dropping them", resolver.Name())
 				}
-				reqs = req.resolvesTo(errored(req.root().u, req.u, err)).Wrap()
+				request.discard()
+				reqs = d.unresolvable(err, request)
 			}
 			return reqs
-		})
+		}})
 	}
 	for resolver, reqs := range multi {
-		rs := reqs
-		fns = append(fns, func() []api.Request {
-			reqs, err := resolver.ResolveMany(reqs)
-			if err != nil {
-				if reqs != nil {
-					panic("non-nil requests on err!")
-				}
-				reqs = make([]api.Request, len(rs))
-				for i, req := range rs {
-					local := req.(*request)
-					reqs[i] = local.resolvesTo(errored(local.root().u, local.u, err))
-				}
-			}
-			return reqs
-		})
+		for _, batch := range d.batches(resolver, reqs) {
+			fns = append(fns, d.resolveMany(resolver, batch))
+		}
+	}
+	for _, req := range unsupported {
+		request := req
+		fns = append(fns, resolveUnit{nil, func() []api.Request {
+			return request.fails(d.noResolver(request)).Wrap()
+		}})
 	}
 	return fns
 }
 
-// returns: (SingleResolvable, MultiResolvable)
-func (d *Client) group(rs []*request) (map[*request]SingleResolver, map[MultiResolver][]api.Request) {
-	single := make(map[*request]SingleResolver)
-	multi := make(map[MultiResolver][]api.Request)
+// resolveMany returns the unit resolving the requests in a single call.
+// The call is aborted when the client stops, or when all containers of the requests are canceled.
+func (d *Client) resolveMany(resolver resolver, rs []api.Request) resolveUnit {
+	return resolveUnit{resolver, func() []api.Request {
+		var reqs []api.Request
+		ctx, cancel := d.batchContext(rs)
+		for _, req := range rs {
+			req.(*request).ctx = ctx
+		}
+		err := d.safely(resolver, "ResolveMany", func() (err error) {
+			reqs, err = multiResolver(resolver).ResolveManyContext(ctx, rs)
+			return err
+		})
+		cancel()
+		for _, req := range rs {
+			req.(*request).ctx = nil
+		}
+		if err != nil {
+			if reqs != nil {
+				d.providerLogger(resolver).Errorf("Client#resolveMany: %v returned requests along with an error, dropping them", resolver.Name())
+			}
+			failed := make([]*request, len(rs))
+			for i, req := range rs {
+				failed[i] = req.(*request)
+				failed[i].discard()
+			}
+			reqs = d.unresolvable(err, failed...)
+		}
+		return reqs
+	}}
+}
+
+// unresolvable handles the error a resolver returned for the requests, depending on its kind.
+// Requests that are resolved again later are not returned.
+func (d *Client) unresolvable(err error, reqs ...*request) []api.Request {
+	err = timedOut(err)
+	if we, ok := err.(*api.WaitError); ok {
+		var waiting []*request
+		var results []api.Request
+		for _, r := range reqs {
+			if r.waits < d.maxWaits() {
+				r.waits++
+				waiting = append(waiting, r)
+			} else {
+				results = append(results, r.resolvesTo(errored(r, r.u, waitedOut(we, d.maxWaits()))))
+			}
+		}
+		if len(waiting) > 0 {
+			d.waitResolving(we, waiting...)
+		}
+		return results
+	}
+	results := make([]api.Request, 0, len(reqs))
+	for _, r := range reqs {
+		switch api.KindOf(err) {
+		case api.NotFound:
+			results = append(results, r.Deadend(nil))
+			continue
+		case api.Unavailable, api.RateLimited:
+			if retry := d.retryPolicy(r.container); r.attempts < retry.Attempts {
+				r.attempts++
+				d.waitResolving(&api.WaitError{Wait: retry.Delay, Reason: err.Error()}, r)
+				continue
+			}
+		}
+		results = append(results, r.resolvesTo(errored(r, r.u, err)))
+	}
+	return results
+}
+
+// returns: (SingleResolvable, MultiResolvable, not resolvable by any provider)
+func (d *Client) group(rs []*request) (map[*request]resolver, map[resolver][]api.Request, []*request) {
+	single := make(map[*request]resolver)
+	multi := make(map[resolver][]api.Request)
+	var unsupported []*request
 	for _, r := range rs {
 		if r.resolved() {
 			panic("Resolved in Client#group: " + r.URL().String())
 		}
 		resolver, ablty := d.resolvability(r)
-		if ablty == api.Single {
-			sr := resolver.(SingleResolver)
-			single[r] = sr
+		if ablty == api.Single && singleResolver(resolver) != nil {
+			single[r] = resolver
+		} else if ablty == api.Multi && multiResolver(resolver) != nil {
+			multi[resolver] = append(multi[resolver], r)
 		} else {
-			mr := resolver.(MultiResolver)
-			multi[mr] = append(multi[mr], r)
+			unsupported = append(unsupported, r)
 		}
 	}
-	return single, multi
+	return single, multi, unsupported
 }
 
+// resolvability returns the provider resolving the request. It returns api.Next if there is none.
 func (d *Client) resolvability(r *request) (resolver, api.Resolvability) {
-	for _, p := range d.Providers {
+	for _, p := range d.resolversFor(r) {
 		if resolver, ok := p.(resolver); ok {
-			switch resolver.CanResolve(r.URL()) {
+			ablty := api.Next
+			d.safely(p, "CanResolve", func() error {
+				ablty = resolver.CanResolve(r.URL())
+				return nil
+			})
+			switch ablty {
 			case api.Single:
 				return resolver, api.Single
 			case api.Multi:
@@ -311,121 +650,347 @@ func (d *Client) resolvability(r *request) (resolver, api.Resolvability) {
 			}
 		}
 	}
-	panic("unreachable")
+	d.logger().Warnf("Client#resolvability: no provider supports %v", r.URL())
+	return nil, api.Next
 }
 
 // === RETRIEVE METHODS ===
 
 func (d *Client) workRetrieve() {
-	for file := range d.ResolvedQueue.get {
-		if file.Err() != nil {
-			panic(fmt.Sprintf("File error in retrieve: %v", file.Err()))
-		} else if file.Offline() {
-			d.emit(eDeadend, file.URL())
-		} else {
-			d.download(file)
+	for {
+		retire, resized := d.retire()
+		if retire {
+			return
+		}
+		var file File
+		var ok bool
+		select {
+		case <-resized:
+			continue
+		case <-d.resumed():
+		}
+		select {
+		case <-resized:
+			continue
+		case file, ok = <-d.ResolvedQueue.get:
+		}
+		if !ok {
+			d.poolMtx.Lock()
+			d.workers--
+			d.poolMtx.Unlock()
+			return
+		}
+		if file.Err() != nil || file.Offline() {
+			// already reported when resolved
+			continue
+		} else if c := file.request().container; c.isCanceled() {
+			c.update(file, FileCanceled, nil)
+			d.emit(CancelEvent{info(file), nil})
 			file.done()
+		} else {
+			atomic.AddInt32(&d.busy, 1)
+			finished := d.retrieve(file)
+			atomic.AddInt32(&d.busy, -1)
+			if finished {
+				file.done()
+			}
+		}
+	}
+}
+
+// retryPolicy returns the RetryPolicy of the container, or the client's if it has none.
+func (d *Client) retryPolicy(c *container) RetryPolicy {
+	if c.opts.Retry.Attempts == 0 {
+		return d.Retry
+	}
+	return c.opts.Retry
+}
+
+// retrieve downloads the file, retrying as often as its container's RetryPolicy allows.
+// Returns false if the file was rescheduled because the provider asked to wait.
+func (d *Client) retrieve(file File) bool {
+	c := file.request().container
+	log := d.fileLogger(file)
+	retry := d.retryPolicy(c)
+	refreshed := false
+	// retrievers and accounts that were refused
+	excluded := make(exclusions)
+	for attempt := 0; ; attempt++ {
+		retriever := d.retriever(file, excluded)
+		if retriever == nil {
+			err := d.noRetriever(file)
+			if len(excluded) > 0 {
+				err = api.Errorf(api.AuthFailed, "no provider with a working account can retrieve %v", file.URL())
+			}
+			c.update(file, FileErrored, err)
+			d.emit(ErrorEvent{info(file), err})
+			return true
+		}
+		if expired(file) {
+			if fresh, err := d.refresh(file); err != nil {
+				log.Warnf("Client#retrieve (%v): link expired, resolving again failed: %v", file.Name(), err)
+			} else {
+				file = fresh
+			}
+		}
+		err := d.download(file, retriever)
+		if err == nil {
+			return true
+		}
+		if c.ctx.Err() != nil {
+			// the container was canceled or the client stopped while the provider was called.
+			c.update(file, FileCanceled, nil)
+			d.emit(CancelEvent{info(file), nil})
+			return true
+		}
+		if we, ok := err.(*api.WaitError); ok {
+			if p := file.request(); p.waits < d.maxWaits() {
+				p.waits++
+				d.wait(file, we)
+				return false
+			}
+			err = waitedOut(we, d.maxWaits())
+			log.Warnf("Client#retrieve (%v): %v", file.Name(), err)
+			c.update(file, FileErrored, err)
+			d.emit(ErrorEvent{info(file), err})
+			return true
+		}
+		if rejected(err) && !refreshed {
+			// the link may have died while it was queued. Resolve it again and retry right away.
+			if fresh, rerr := d.refresh(file); rerr == nil {
+				log.Infof("Client#retrieve (%v): %v, retrying with a fresh link", file.Name(), err)
+				file, refreshed = fresh, true
+				attempt--
+				continue
+			}
+		}
+		kind := api.KindOf(err)
+		switch kind {
+		case api.NotFound:
+			log.Infof("Client#retrieve (%v): %v, offline", file.Name(), err)
+			c.update(file, FileOffline, err)
+			d.emit(DeadendEvent{info(file)})
+			return true
+		case api.AuthFailed, api.QuotaExceeded:
+			// switch to another provider, e.g. one with a premium account
+			acc := d.accountOf(retriever, file)
+			if acc != nil {
+				log.Warnf("Client#retrieve (%v): %v (account %v): %v, trying another account", file.Name(), retriever.Name(), acc.ID(), err)
+			} else {
+				log.Warnf("Client#retrieve (%v): %v: %v, trying another provider", file.Name(), retriever.Name(), err)
+			}
+			excluded.add(retriever, acc)
+			attempt--
+			continue
+		}
+		atomic.AddInt64(&d.failures, 1)
+		if attempt >= retry.Attempts || kind == api.Unsupported {
+			c.update(file, FileErrored, err)
+			d.emit(ErrorEvent{info(file), err})
+			return true
+		}
+		log.Warnf("Client#retrieve (%v): attempt %v failed: %v", file.Name(), attempt+1, err)
+		if err != ErrStalled {
+			// a stalled download reconnects right away, continuing where it stopped.
+			time.Sleep(retry.Delay)
 		}
 	}
 }
 
-func max(ps []Provider, f func(Provider) uint) Provider {
-	var max uint
+// exclusions are the accounts that failed to retrieve a file, by provider.
+// The empty ID stands for all accounts of the provider.
+type exclusions map[Provider]map[string]bool
+
+func (e exclusions) add(p Provider, acc Account) {
+	if e[p] == nil {
+		e[p] = make(map[string]bool)
+	}
+	if acc == nil {
+		e[p][""] = true
+	} else {
+		e[p][acc.ID()] = true
+	}
+}
+
+// excludes returns whether the provider failed to retrieve the file with the account it would use now.
+// Providers that do not tell their accounts (see Accounted) are excluded as a whole.
+func (d *Client) excludes(e exclusions, p Provider, file File) bool {
+	failed := e[p]
+	if failed == nil {
+		return false
+	}
+	if failed[""] {
+		return true
+	}
+	acc := d.accountOf(p, file)
+	return acc == nil || failed[acc.ID()]
+}
+
+// best returns the provider with the highest score, nil if none scores above 0.
+func best(ps []Provider, scores []float64) Provider {
+	var max float64
 	var maxP Provider
-	for _, p := range ps {
-		prio := f(p)
-		if prio > max {
+	for i, p := range ps {
+		if scores[i] > max {
 			maxP = p
-			max = prio
+			max = scores[i]
 		}
 	}
 	return maxP
 }
 
-// Download retrieves the given File
-func (d *Client) download(file File) {
-	retriever := max(d.Providers, func(p Provider) uint {
-		if getter, ok := p.(Retriever); ok {
-			prio := getter.CanRetrieve(file)
-			logrus.Debugf("Client#download (%v): provider %v with prio %v", file.Name(), p.Name(), prio)
-			return prio
+// retriever returns the most suitable provider for retrieving the file, nil if there is none.
+// The priorities the providers declare are weighed with their statistics, if the client records them.
+func (d *Client) retriever(file File, excluded exclusions) Provider {
+	candidates := d.retrieversFor(file)
+	prios := make([]uint, len(candidates))
+	for i, p := range candidates {
+		if getter := contextRetriever(p); getter != nil && !d.excludes(excluded, p, file) {
+			d.safely(p, "CanRetrieve", func() error {
+				prios[i] = d.biased(p, getter.CanRetrieve(file))
+				return nil
+			})
+			d.fileLogger(file).Debugf("Client#retriever (%v): provider %v with prio %v", file.Name(), p.Name(), prios[i])
 		}
-		return 0
-	}).(Retriever)
+	}
+	return best(candidates, d.rank(file, candidates, prios))
+}
 
-	path := filepath.Join(d.Directory, file.Name())
+// download fetches the given File once. It returns the error that made it fail, if any.
+func (d *Client) download(file File, retriever Provider) error {
+	c := file.request().container
+	log := d.fileLogger(file).WithFields(Fields{"provider": retriever.Name()})
+	opts := c.opts
+	dir := opts.Directory
+	if dir == "" {
+		dir = d.Directory
+	}
+	path := filepath.Join(dir, file.Name())
 	fi, err := os.Stat(path)
 	headers := map[string]string{}
 	if err == nil {
-		logrus.Debugf("Client#download (%v): local: %v, remote: %v", file.Name(), fi.Size(), file.Size())
+		log.Debugf("Client#download (%v): local: %v, remote: %v", file.Name(), fi.Size(), file.Size())
 		if fi.Size() == file.Size() {
-			if !d.NoSkip {
-				logrus.Debugf("Client#download (%v): already exists... returning", file.Name())
-				d.emit(eSkip, file)
-				return
+			if !d.NoSkip && !opts.NoSkip {
+				log.Debugf("Client#download (%v): already exists... returning", file.Name())
+				c.update(file, FileSkipped, nil)
+				d.emit(SkipEvent{info(file)})
+				return nil
 			}
-			logrus.Debugf("Client#download (%v): already exists... deleting", file.Name())
-			err = os.Remove(path)
-			if err != nil {
-				d.emit(eError, file, err)
-				return
+			log.Debugf("Client#download (%v): already exists... deleting", file.Name())
+			if err = os.Remove(path); err != nil {
+				return err
 			}
-		} else if !d.NoContinue {
+		} else if !d.NoContinue && !opts.NoContinue {
 			headers["Range"] = fmt.Sprintf("bytes=%d-", fi.Size())
-			logrus.Infof("Client#download (%v): +header range %s", file.Name(), headers["Range"])
+			log.Infof("Client#download (%v): +header range %s", file.Name(), headers["Range"])
 		}
 	} else if !os.IsNotExist(err) {
-		d.emit(eError, 0, err)
-		return
+		return err
 	}
-	if !d.dryRun("fetch %s with %s provider.", file.Name(), retriever.Name()) {
-		if req, err := retriever.Retrieve(file); err == nil {
-			for k, v := range headers {
-				req.Header.Set(k, v)
-			}
-			ctx, cancel := context.WithCancel(req.Context())
-			defer cancel()
-			req = req.WithContext(ctx)
-			resp, err := d.httpClient.Do(req)
-			if err != nil {
-				d.emit(eError, file, err)
-				return
-			}
-			defer resp.Body.Close()
-			logrus.Debugf("Client#download (%v): > %v", file.Name(), resp.Request.Header)
-			logrus.Debugf("Client#download (%v): %v", file.Name(), resp.Status)
-			for k, v := range resp.Header {
-				logrus.Debugf("  < %v: %v", k, v)
-			}
-			// Disallow redirects as well -- we haven't set a redirect handler
-			if !strings.HasPrefix(resp.Status, "2") {
-				logrus.Errorf("Client#download (%v): %v", file.Name(), resp.Status)
-				d.emit(eError, file, fmt.Errorf("status code %v", resp.Status))
-				return
-			}
-			reader := &passThru{length: resp.ContentLength, Reader: resp.Body}
-			openFlags := os.O_WRONLY | os.O_CREATE
-			if resp.StatusCode == http.StatusPartialContent {
-				openFlags |= os.O_APPEND
-				reader.progress = fi.Size()
-				reader.length += reader.progress
-			} else if resp.StatusCode != http.StatusOK {
-				logrus.Warnf("Client#download (%v): unknown status code %v", file.Name(), resp.StatusCode)
-			}
-			f, err := os.OpenFile(path, openFlags, 0644)
-			if err != nil {
-				d.emit(eError, file, err)
-				return
+	if d.dryRun("fetch %s with %s provider.", file.Name(), retriever.Name()) {
+		return nil
+	}
+	var req *http.Request
+	ctx, cancel := d.callContext(c.ctx)
+	err = d.safely(retriever, "Retrieve", func() (err error) {
+		req, err = contextRetriever(retriever).RetrieveContext(ctx, file)
+		return err
+	})
+	cancel()
+	if err != nil {
+		if c.ctx.Err() == nil {
+			d.measure(attempt{provider: retriever, account: d.accountOf(retriever, file)})
+		}
+		return timedOut(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// the transfer itself is not limited by Client.Timeout, the Watchdog takes care of stalls.
+	ctx, cancel = context.WithCancel(c.ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	measured := attempt{provider: retriever, account: d.accountOf(retriever, file)}
+	requested := time.Now()
+	resp, err := d.downloadClient().Do(req)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			d.measure(measured)
+		}
+		return err
+	}
+	measured.responded = true
+	measured.waiting = time.Since(requested)
+	defer resp.Body.Close()
+	log.Debugf("Client#download (%v): > %v", file.Name(), resp.Request.Header)
+	log.Debugf("Client#download (%v): %v", file.Name(), resp.Status)
+	for k, v := range resp.Header {
+		log.Debugf("  < %v: %v", k, v)
+	}
+	// Disallow redirects as well -- we haven't set a redirect handler
+	if wait, ok := retryAfter(resp); ok {
+		return &api.WaitError{Wait: wait, Reason: resp.Status}
+	}
+	if !strings.HasPrefix(resp.Status, "2") {
+		log.Errorf("Client#download (%v): %v", file.Name(), resp.Status)
+		d.measure(measured)
+		return StatusError{resp.StatusCode, resp.Status}
+	}
+	reader := &passThru{length: resp.ContentLength, Reader: resp.Body, limiter: d.limiter, total: &d.transferred}
+	openFlags := os.O_WRONLY | os.O_CREATE
+	if resp.StatusCode == http.StatusPartialContent {
+		openFlags |= os.O_APPEND
+		reader.progress = fi.Size()
+		reader.length += reader.progress
+	} else {
+		openFlags |= os.O_TRUNC
+		if resp.StatusCode != http.StatusOK {
+			log.Warnf("Client#download (%v): unknown status code %v", file.Name(), resp.StatusCode)
+		}
+	}
+	if dir != "" {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, openFlags, 0644)
+	if err != nil {
+		return err
+	}
+	download := download(file, reader).to(f).via(retriever)
+	download.cancel = cancel
+	download.log = log
+	if !c.downloading(file, download) {
+		f.Close()
+		c.update(file, FileCanceled, nil)
+		d.emit(CancelEvent{info(file), nil})
+		return nil
+	}
+	d.emit(DownloadEvent{info(file), download})
+	unwatch := d.Watchdog.watch(download, d.share)
+	download.do()
+	unwatch()
+	log.Debugf("Client#download (%v): EXIT", file.Name())
+	if download.canceled {
+		c.update(file, FileCanceled, nil)
+		d.emit(CancelEvent{info(file), download})
+		return nil
+	}
+	measured.ok = download.err == nil
+	measured.bytes = download.Bytes()
+	measured.transfer = download.Duration()
+	d.measure(measured)
+	if download.err == nil {
+		c.update(file, FileDone, nil)
+		if d.Session != nil {
+			if err := d.Session.done(c, file); err != nil {
+				log.Errorf("Session#save: %v", err)
 			}
-			download := download(file, reader).to(f).via(retriever)
-			download.cancel = cancel
-			d.emit(eDownload, download)
-			download.do()
-		} else {
-			d.emit(eError, file, err)
 		}
 	}
-	logrus.Debugf("Client#download (%v): EXIT", file.Name())
+	d.emit(completed(download))
+	return download.err
 }
 
 // PassThru wraps an existing io.Reader.
@@ -436,6 +1001,8 @@ type passThru struct {
 	io.Reader
 	progress int64 // Total # of bytes transferred
 	length   int64 // content length
+	limiter  *rate.Limiter
+	total    *int64 // bytes transferred by all downloads
 }
 
 // Read 'overrides' the underlying io.Reader's Read method.
@@ -444,6 +1011,12 @@ type passThru struct {
 func (pt *passThru) Read(p []byte) (int, error) {
 	n, err := pt.Reader.Read(p)
 	atomic.AddInt64(&pt.progress, int64(n))
+	if pt.total != nil {
+		atomic.AddInt64(pt.total, int64(n))
+	}
+	if pt.limiter != nil {
+		pt.limiter.WaitN(n)
+	}
 	return n, err
 }
 
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/uget/uget/core/api"
+)
+
+// instantProvider resolves every link of its host to a file right away.
+type instantProvider struct{ hostProvider }
+
+func (p *instantProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	return r.ResolvesTo(testFile{r.URL(), p}).Wrap(), nil
+}
+
+func BenchmarkResolve100k(b *testing.B) {
+	urls := make([]*url.URL, 100000)
+	for i := range urls {
+		urls[i] = &url.URL{Scheme: "http", Host: "host", Path: fmt.Sprintf("/%d", i)}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewClientWith(0)
+		d.Providers = Providers{&instantProvider{hostProvider{"host"}}}
+		c := d.AddURLs(urls)
+		d.Resolve()
+		c.Wait()
+	}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func workers(d *Client) int {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	return d.workers
+}
+
+func TestSetConcurrency(t *testing.T) {
+	d := NewClientWith(2)
+	d.Providers = Providers{}
+	d.SetConcurrency(3)
+	assert.Equal(t, 3, d.Concurrency())
+	assert.Equal(t, 0, workers(d))
+	d.Start()
+	assert.Equal(t, 3, workers(d))
+	d.SetConcurrency(5)
+	assert.Equal(t, 5, workers(d))
+	d.SetConcurrency(0)
+	assert.Equal(t, 1, d.Concurrency())
+	// idle retrievers stop right away
+	for i := 0; i < 100 && workers(d) > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, workers(d))
+
+	// no effect in 'Resolve' mode
+	r := NewClientWith(0)
+	r.SetConcurrency(4)
+	assert.Equal(t, 0, r.Concurrency())
+}
+
+func TestSetConcurrencyEndsAutoscale(t *testing.T) {
+	d := NewClientWith(2)
+	d.Providers = Providers{}
+	d.Autoscale = &Autoscale{Min: 4, Max: 8, Interval: time.Millisecond}
+	d.Start()
+	defer d.Stop()
+	for i := 0; i < 100 && d.Concurrency() != 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 4, d.Concurrency())
+	// below the autoscaling bounds, which would be restored on the next interval
+	d.SetConcurrency(2)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, d.Concurrency())
+}
@@ -1,31 +1,364 @@
 package core
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Container combines URLs that were added in the same context
 type Container interface {
 	ID() ContainerID
+	Options() ContainerOptions
 	Wait()
+
+	// Status returns a snapshot of this container's progress.
+	Status() ContainerStatus
+
+	// Progress sends a status snapshot every interval. Snapshots are skipped while the previous one is unread.
+	// The channel is closed after the final snapshot, once all files are finished.
+	Progress(interval time.Duration) <-chan ContainerStatus
+
+	// Files lists the resolved files of this container in the order they were resolved,
+	// followed by the requests that wait to be resolved again.
+	Files() []FileStatus
+
+	// Cancel stops all running downloads of this container and drops its remaining files.
+	Cancel()
+}
+
+// ContainerOptions customizes how the files of a single container are resolved and downloaded.
+// Zero values fall back to the Client's settings.
+type ContainerOptions struct {
+	Directory  string      `json:"directory,omitempty"` // target directory, Client.Directory if empty
+	Name       string      `json:"name,omitempty"`
+	Priority   int         `json:"priority,omitempty"`    // files with lower values are retrieved first
+	NoSkip     bool        `json:"no_skip,omitempty"`     // in addition to Client.NoSkip
+	NoContinue bool        `json:"no_continue,omitempty"` // in addition to Client.NoContinue
+	Filter     Filter      `json:"filter"`
+	Retry      RetryPolicy `json:"retry"` // Client.Retry if Attempts is 0
+	Tags       []string    `json:"tags,omitempty"`
+
+	Providers ProviderChoice            `json:"providers"`
+	Overrides map[string]ProviderChoice `json:"overrides,omitempty"` // by link, take precedence over Providers
+}
+
+// Filter selects which resolved files of a container are retrieved.
+// Files that do not match are skipped.
+type Filter struct {
+	Include []string `json:"include,omitempty"`  // glob patterns, the file name must match one of them if any
+	Exclude []string `json:"exclude,omitempty"`  // glob patterns, the file name must not match any of them
+	MinSize int64    `json:"min_size,omitempty"` // in bytes, 0 means no limit
+	MaxSize int64    `json:"max_size,omitempty"` // in bytes, 0 means no limit
+}
+
+// Match returns whether the given (available) file passes this filter.
+// Size limits do not apply to files of unknown length.
+func (f Filter) Match(file File) bool {
+	if len(f.Include) > 0 && !globs(f.Include, file.Name()) {
+		return false
+	}
+	if globs(f.Exclude, file.Name()) {
+		return false
+	}
+	if file.LengthUnknown() {
+		return true
+	}
+	return (f.MinSize == 0 || file.Size() >= f.MinSize) && (f.MaxSize == 0 || file.Size() <= f.MaxSize)
+}
+
+func globs(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy defines how often a failed download is attempted again.
+type RetryPolicy struct {
+	Attempts int           `json:"attempts,omitempty"` // attempts after the first one failed
+	Delay    time.Duration `json:"delay,omitempty"`    // pause between two attempts
 }
 
 type container struct {
-	id ContainerID
-	wg *sync.WaitGroup
+	id       ContainerID
+	idOnce   sync.Once
+	idString string     // of id, hashing all URLs once is enough
+	raw      []*url.URL // the URLs of id as they were passed to the Client, before they were canonicalized
+	opts     ContainerOptions
+	wg       *sync.WaitGroup
+	finished chan struct{}
+	created  time.Time
+	ctx      context.Context // done when the container is canceled or finished, or the client stopped
+	cancel   context.CancelFunc
+	// IDs of files that were retrieved in a previous session
+	retrieved map[string]bool
+	journal   int64 // ID in the Session, 0 if there is none
+
+	mtx      sync.Mutex
+	pending  int // unresolved requests
+	bundled  int // requests generated by Bundles
+	canceled bool
+	entries  []*entry
+	waits    []*entry // of unresolved requests that wait for their resolver
 }
 
-func (c container) Wait() {
+// entry tracks the state of a single resolved file
+type entry struct {
+	file     File
+	state    FileState
+	download *Download // the latest download
+	err      error
+	until    time.Time // when a waiting file is retried
+
+	transferred int64 // by previous downloads
+}
+
+func newContainer(parent context.Context, urls []*url.URL, opts ContainerOptions) *container {
+	ctx, cancel := context.WithCancel(parent)
+	c := &container{
+		ctx:      ctx,
+		cancel:   cancel,
+		id:       ContainerID(urls),
+		raw:      urls,
+		opts:     opts,
+		wg:       new(sync.WaitGroup),
+		finished: make(chan struct{}),
+		created:  time.Now(),
+		pending:  len(urls),
+	}
+	// +1 for the job that enqueues the root requests
+	c.wg.Add(len(urls) + 1)
+	go func() {
+		c.wg.Wait()
+		close(c.finished)
+		cancel()
+	}()
+	return c
+}
+
+// transferred returns the number of bytes transferred by all downloads of this container
+func (c *container) transferred() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var n int64
+	for _, e := range c.entries {
+		n += e.transferred
+		if e.download != nil {
+			n += e.download.Bytes()
+		}
+	}
+	return n
+}
+
+func (c *container) Options() ContainerOptions {
+	return c.opts
+}
+
+func (c *container) Wait() {
 	c.wg.Wait()
 }
 
-func (c container) ID() ContainerID {
+func (c *container) ID() ContainerID {
 	return c.id
 }
 
+// name returns the ID as string. Unlike ID().String(), it does not hash the URLs on every call.
+func (c *container) name() string {
+	c.idOnce.Do(func() {
+		c.idString = c.id.String()
+	})
+	return c.idString
+}
+
+func (c *container) Status() ContainerStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	status := ContainerStatus{Resolving: c.pending - len(c.waits), Stopped: c.canceled}
+	for _, e := range c.entries {
+		status.add(e)
+	}
+	for _, e := range c.waits {
+		status.add(e)
+	}
+	select {
+	case <-c.finished:
+		status.Finished = true
+	default:
+	}
+	return status
+}
+
+func (c *container) Progress(interval time.Duration) <-chan ContainerStatus {
+	// the goroutine never blocks, so it ends with the container even if nobody reads anymore.
+	ch := make(chan ContainerStatus, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case ch <- c.Status():
+				default:
+					// the previous snapshot was not read yet
+				}
+			case <-c.finished:
+				// replace a snapshot that was not read by the final one
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- c.Status()
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (c *container) Files() []FileStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	files := make([]FileStatus, 0, len(c.entries)+len(c.waits))
+	for _, e := range c.entries {
+		files = append(files, e.status())
+	}
+	for _, e := range c.waits {
+		files = append(files, e.status())
+	}
+	return files
+}
+
+func (c *container) Cancel() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.canceled {
+		return
+	}
+	c.canceled = true
+	c.cancel()
+	for _, e := range c.entries {
+		if e.state == FileDownloading && e.download != nil {
+			e.download.Stop()
+		}
+	}
+}
+
+func (c *container) isCanceled() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.canceled
+}
+
+// grow accounts for n additional unresolved requests (n can be negative).
+func (c *container) grow(n int) {
+	c.mtx.Lock()
+	c.pending += n
+	c.mtx.Unlock()
+	c.wg.Add(n)
+}
+
+// bundle accounts for n requests generated by Bundles. It returns the number of those bundled before.
+func (c *container) bundle(n int) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.bundled += n
+	return c.bundled - n
+}
+
+// resolved registers the file a request resolved to.
+func (c *container) resolved(f File, state FileState) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pending--
+	f.request().entry = len(c.entries) + 1
+	c.entries = append(c.entries, &entry{file: f, state: state, err: f.Err()})
+}
+
+// entry returns the entry of the resolved file. The caller must hold the lock.
+func (c *container) entry(f File) (*entry, bool) {
+	if i := f.request().entry; i > 0 && i <= len(c.entries) {
+		return c.entries[i-1], true
+	}
+	return nil, false
+}
+
+// relink replaces an already resolved file with one that resolved from the same request.
+func (c *container) relink(f File) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entry(f); ok {
+		e.file = f
+	}
+}
+
+// waiting marks the file as waiting for the provider until the given time.
+func (c *container) waiting(f File, until time.Time, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entry(f); ok {
+		e.state, e.until, e.err = FileWaiting, until, err
+	}
+}
+
+// waitingUnresolved marks the unresolved request of f as waiting for its resolver until the given time.
+func (c *container) waitingUnresolved(f File, until time.Time, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.waits = append(c.waits, &entry{file: f, state: FileWaiting, until: until, err: err})
+}
+
+// resumed ends the wait of the unresolved request of f.
+func (c *container) resumed(f File) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for i, e := range c.waits {
+		if e.file.request() == f.request() {
+			c.waits = append(c.waits[:i], c.waits[i+1:]...)
+			return
+		}
+	}
+}
+
+// update sets the state of an already resolved file.
+func (c *container) update(f File, state FileState, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entry(f)
+	if !ok {
+		return
+	}
+	e.state = state
+	e.err = err
+}
+
+// downloading marks the file as being retrieved by dl.
+// Returns false if the container was canceled, in which case the download must not be started.
+func (c *container) downloading(f File, dl *Download) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.canceled {
+		return false
+	}
+	if e, ok := c.entry(f); ok {
+		if e.download != nil {
+			e.transferred += e.download.Bytes()
+		}
+		e.state = FileDownloading
+		e.download = dl
+		e.err = nil
+	}
+	return true
+}
+
 // ContainerID calculates the sha256 sum of the underlying URLs
 type ContainerID []*url.URL
 
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uget/uget/core/api"
+)
+
+// callContext returns the context of a single provider call, limited to Client.Timeout.
+func (d *Client) callContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if d.Timeout > 0 {
+		return context.WithTimeout(parent, d.Timeout)
+	}
+	// not deriving a context saves registering it with the parent, once per call.
+	return parent, func() {}
+}
+
+// batchContext returns the context of a call resolving the requests at once.
+// It is canceled once the containers of all requests are canceled.
+func (d *Client) batchContext(rs []api.Request) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(d.ctx, d.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(d.ctx)
+	}
+	containers := make(map[*container]bool)
+	for _, r := range rs {
+		containers[r.(*request).container] = true
+	}
+	go func() {
+		for c := range containers {
+			select {
+			case <-c.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// singleResolver returns the resolver as ContextSingleResolver, nil if it resolves no single URLs.
+func singleResolver(r resolver) ContextSingleResolver {
+	switch sr := r.(type) {
+	case ContextSingleResolver:
+		return sr
+	case SingleResolver:
+		return api.ContextSingle(sr)
+	}
+	return nil
+}
+
+// multiResolver returns the resolver as ContextMultiResolver, nil if it resolves no batches.
+func multiResolver(r resolver) ContextMultiResolver {
+	switch mr := r.(type) {
+	case ContextMultiResolver:
+		return mr
+	case MultiResolver:
+		return api.ContextMulti(mr)
+	}
+	return nil
+}
+
+// contextRetriever returns the provider as ContextRetriever, nil if it retrieves no files.
+func contextRetriever(p Provider) ContextRetriever {
+	switch r := p.(type) {
+	case ContextRetriever:
+		return r
+	case Retriever:
+		return api.ContextRetrieve(r)
+	}
+	return nil
+}
+
+// timedOut classifies a provider call that exceeded Client.Timeout as api.Unavailable, so it is retried.
+// Providers usually return the deadline wrapped, e.g. by net/http. Errors they classified themselves are kept.
+func timedOut(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && api.KindOf(err) == api.Unknown {
+		return api.Wrap(api.Unavailable, err)
+	}
+	return err
+}
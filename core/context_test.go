@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+// blockingProvider does not return before the context of the call is done
+type blockingProvider struct{ called chan api.Request }
+
+func (p *blockingProvider) Name() string                            { return "blocking" }
+func (p *blockingProvider) CanResolve(u *url.URL) api.Resolvability { return api.Single }
+
+func (p *blockingProvider) ResolveOneContext(ctx context.Context, r api.Request) ([]api.Request, error) {
+	p.called <- r
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResolveCanceled(t *testing.T) {
+	p := &blockingProvider{make(chan api.Request, 1)}
+	d := NewClientWith(0)
+	d.Providers = Providers{p}
+	c := d.AddURLs([]*url.URL{{Scheme: "http", Host: "host", Path: "/file"}})
+	d.Resolve()
+	r := <-p.called
+	assert.NoError(t, r.Context().Err())
+	c.Cancel()
+	c.Wait()
+	assert.Error(t, r.Context().Err())
+	files := c.Files()
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, FileCanceled, files[0].State)
+	}
+}
+
+// batchProvider records the deadlines its requests see through Context
+type batchProvider struct{ deadlines chan bool }
+
+func (p *batchProvider) Name() string                            { return "batch" }
+func (p *batchProvider) CanResolve(u *url.URL) api.Resolvability { return api.Multi }
+
+func (p *batchProvider) ResolveManyContext(ctx context.Context, rs []api.Request) ([]api.Request, error) {
+	results := make([]api.Request, len(rs))
+	for i, r := range rs {
+		_, ok := r.Context().Deadline()
+		p.deadlines <- ok && r.Context() == ctx
+		results[i] = r.Deadend(nil)
+	}
+	return results, nil
+}
+
+func TestResolveManyContext(t *testing.T) {
+	p := &batchProvider{make(chan bool, 2)}
+	d := NewClientWith(0)
+	d.Timeout = time.Hour
+	d.Providers = Providers{p}
+	c := d.AddURLs([]*url.URL{
+		{Scheme: "http", Host: "host", Path: "/a"},
+		{Scheme: "http", Host: "host", Path: "/b"},
+	})
+	d.Resolve()
+	c.Wait()
+	assert.True(t, <-p.deadlines)
+	assert.True(t, <-p.deadlines)
+}
+
+func TestBatchContext(t *testing.T) {
+	d := NewClientWith(0)
+	d.Timeout = time.Hour
+	u := &url.URL{Scheme: "http", Host: "host", Path: "/file"}
+	a := newContainer(d.ctx, []*url.URL{u}, ContainerOptions{})
+	b := newContainer(d.ctx, []*url.URL{u}, ContainerOptions{})
+	ctx, cancel := d.batchContext([]api.Request{rootRequest(u, a, 0), rootRequest(u, b, 0)})
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.True(t, ok)
+	// the call is still needed for the other container
+	a.Cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatal("batch canceled with one of its containers")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.Cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("batch not canceled with all of its containers")
+	}
+}
+
+func TestTimedOut(t *testing.T) {
+	assert.Equal(t, api.Unavailable, api.KindOf(timedOut(context.DeadlineExceeded)))
+	// like net/http returns it
+	wrapped := &url.Error{Op: "Get", URL: "http://host/file", Err: fmt.Errorf("reading body: %w", context.DeadlineExceeded)}
+	assert.Equal(t, api.Unavailable, api.KindOf(timedOut(wrapped)))
+	assert.Equal(t, api.NotFound, api.KindOf(timedOut(api.Wrap(api.NotFound, context.DeadlineExceeded))))
+	err := errors.New("failed")
+	assert.Equal(t, err, timedOut(err))
+	assert.Equal(t, context.Canceled, timedOut(context.Canceled))
+}
@@ -1,11 +1,17 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sync/atomic"
+	"time"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/uget/uget/core/api"
 )
 
 // Download is an object that fetches a single remote file
@@ -15,12 +21,47 @@ type Download struct {
 	File     File
 	file     *os.File
 	reader   ReadProgress
+	offset   int64 // bytes that were already present locally
 	canceled bool
+	verified bool
+	stopped  int32 // set atomically by Stop
+	stalled  int32 // set atomically by the Watchdog
 	cancel   context.CancelFunc
+	log      Logger
+	started  time.Time
+	finished time.Time
 	done     chan struct{}
 	err      error
 }
 
+// StatusError is the error of a download the server answered with an unexpected status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("status code %v", e.Status)
+}
+
+// Kind classifies the status
+func (e StatusError) Kind() api.ErrorKind {
+	switch {
+	case e.Code == http.StatusNotFound || e.Code == http.StatusGone:
+		return api.NotFound
+	case e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden:
+		return api.AuthFailed
+	case e.Code == http.StatusTooManyRequests:
+		return api.RateLimited
+	case e.Code >= 500:
+		return api.Unavailable
+	}
+	return api.Unknown
+}
+
+// ErrChecksumMismatch is the error of a download whose checksum does not match the remote one.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 // Done returns true if this download is finished. False otherwise
 func (d *Download) Done() bool {
 	select {
@@ -49,11 +90,39 @@ func (d *Download) Err() error {
 	return d.err
 }
 
+// Verified returns whether the downloaded file matched the checksum provided by the File.
+// Panics if download is still running.
+func (d *Download) Verified() bool {
+	if !d.Done() {
+		panic("Called Download#Verified() when download is still running!")
+	}
+	return d.verified
+}
+
 // Progress returns the current progress in int64
 func (d *Download) Progress() int64 {
 	return d.reader.Progress()
 }
 
+// Bytes returns the number of bytes transferred by this download,
+// i.e. excluding those of a previous download that was continued.
+func (d *Download) Bytes() int64 {
+	return d.Progress() - d.offset
+}
+
+// Duration returns how long this download has been running, or took if it is done.
+func (d *Download) Duration() time.Duration {
+	if d.Done() {
+		return d.finished.Sub(d.started)
+	}
+	return time.Since(d.started)
+}
+
+// AverageSpeed returns the average number of bytes transferred per second.
+func (d *Download) AverageSpeed() float64 {
+	return float64(d.Bytes()) / d.Duration().Seconds()
+}
+
 func (d *Download) Size() int64 {
 	return d.reader.Length()
 }
@@ -70,15 +139,25 @@ func (d *Download) Waiter() <-chan struct{} {
 
 // Stop cancels this download
 func (d *Download) Stop() {
+	atomic.StoreInt32(&d.stopped, 1)
+	d.cancel()
+}
+
+// stall aborts this download, so that it is continued on a new connection.
+func (d *Download) stall() {
+	atomic.StoreInt32(&d.stalled, 1)
 	d.cancel()
 }
 
 // Download initalizes a Download object from the given File and ReadCloser
 func download(file File, reader ReadProgress) *Download {
 	return &Download{
-		File:   file,
-		reader: reader,
-		done:   make(chan struct{}),
+		File:    file,
+		reader:  reader,
+		offset:  reader.Progress(),
+		started: time.Now(),
+		log:     NopLogger,
+		done:    make(chan struct{}),
 	}
 }
 
@@ -97,14 +176,45 @@ func (d *Download) via(p Provider) *Download {
 func (d *Download) do() {
 	defer close(d.done)
 	_, d.err = io.Copy(d.file, d.reader)
-	if d.err == context.Canceled {
+	d.finished = time.Now()
+	if d.err != nil && atomic.LoadInt32(&d.stalled) == 1 {
+		d.err = ErrStalled
+	} else if d.err == context.Canceled || d.err != nil && atomic.LoadInt32(&d.stopped) == 1 {
 		d.err = nil
 		d.canceled = true
 	}
 	if err := d.file.Close(); err != nil {
-		logrus.Errorf("Closing file failed: %v", err)
+		d.log.Errorf("Closing file failed: %v", err)
+	}
+	if d.err == nil && !d.canceled {
+		d.verified, d.err = d.verify()
+	}
+	d.log.Debugf("Download#start: %v done, err: %v.", d.File.Name(), d.err)
+}
+
+// verify compares the local file against the remote checksum, if there is one.
+// Mismatching files are removed so they are not mistaken for complete ones.
+func (d *Download) verify() (bool, error) {
+	sum, algo, h := d.File.Checksum()
+	if sum == nil || h == nil {
+		return false, nil
+	}
+	f, err := os.Open(d.file.Name())
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return false, err
+	}
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		d.log.Errorf("Download#verify: %v: %s checksum mismatch", d.File.Name(), algo)
+		if err = os.Remove(d.file.Name()); err != nil {
+			d.log.Errorf("Download#verify: removing %v: %v", d.file.Name(), err)
+		}
+		return false, ErrChecksumMismatch
 	}
-	logrus.Debugf("Download#start: %v done, err: %v.", d.File.Name(), d.err)
+	return true, nil
 }
 
 // Progress is an object that represents a long operation that can track a progress
@@ -0,0 +1,419 @@
+package core
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uget/uget/core/api"
+)
+
+// Event is emitted by the Client whenever a file changes its state.
+// Use a type switch to distinguish the different kinds of events.
+//
+// Events concerning the same file are always delivered in the order they occurred.
+type Event interface {
+	Info() EventInfo
+}
+
+// EventInfo holds the fields common to all events
+type EventInfo struct {
+	Time      time.Time
+	Container Container
+	File      File
+}
+
+// Info returns the common fields of this event
+func (e EventInfo) Info() EventInfo {
+	return e
+}
+
+// ResolveEvent is emitted when a URL was resolved to a File.
+// Err is set if resolving failed.
+type ResolveEvent struct {
+	EventInfo
+	URL *url.URL
+	Err error
+}
+
+// DownloadEvent is emitted when a download is started
+type DownloadEvent struct {
+	EventInfo
+	Download *Download
+}
+
+// ErrorEvent is emitted when resolving or retrieving a file failed for good
+type ErrorEvent struct {
+	EventInfo
+	Err error
+}
+
+// SkipEvent is emitted when a file is not retrieved because it exists locally or is filtered
+type SkipEvent struct {
+	EventInfo
+}
+
+// DeadendEvent is emitted when a file is offline
+type DeadendEvent struct {
+	EventInfo
+}
+
+// CompleteEvent is emitted when a download finished, whether successfully or not.
+// Err is set if the download failed; it might be retried afterwards.
+type CompleteEvent struct {
+	EventInfo
+	Download *Download
+	Duration time.Duration
+	Bytes    int64   // transferred by this download
+	Speed    float64 // average bytes per second
+	Verified bool    // whether the checksum of the File was verified
+	Err      error
+}
+
+// WaitEvent is emitted when the provider asked to wait before retrieving the file.
+// The file is retried at Until, and does not occupy a retriever meanwhile.
+type WaitEvent struct {
+	EventInfo
+	Until time.Time
+	Err   *api.WaitError
+}
+
+// CancelEvent is emitted when a file is canceled.
+// Download is nil if the file was canceled before it was started.
+type CancelEvent struct {
+	EventInfo
+	Download *Download
+}
+
+// ContainerDoneEvent is emitted when all files of a container are finished.
+// Its File is nil.
+type ContainerDoneEvent struct {
+	EventInfo
+	Status   ContainerStatus
+	Duration time.Duration
+	Bytes    int64   // transferred by all downloads of the container
+	Speed    float64 // average bytes per second
+	Errors   []error
+}
+
+// Backpressure defines what happens when a subscriber does not keep up with the events.
+type Backpressure int
+
+const (
+	// Block makes the Client wait until the subscriber has room for the event.
+	// Note that this stalls the Client for all subscribers.
+	Block Backpressure = iota
+	// DropNewest discards the events that do not fit into the subscriber's buffer.
+	DropNewest
+	// DropOldest discards the oldest buffered event to make room for the new one.
+	DropOldest
+)
+
+// SubscribeOptions configures a Subscription
+type SubscribeOptions struct {
+	Buffer       int // number of events buffered for the subscriber, defaults to 64
+	Backpressure Backpressure
+}
+
+// Subscription is a stream of the Client's events.
+type Subscription struct {
+	bus     *bus
+	events  chan Event
+	policy  Backpressure
+	quit    chan struct{}
+	once    sync.Once
+	mtx     sync.Mutex // held while sending, so that events is not closed meanwhile
+	closed  bool
+	dropped uint64
+}
+
+// Events returns the channel of events. It is closed after the subscription ended.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events that were discarded due to the Backpressure policy.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe ends this subscription. Can be called multiple times.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// unblock a pending send before waiting for it
+		close(s.quit)
+		s.bus.remove(s)
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.closed = true
+		close(s.events)
+	})
+}
+
+func (s *Subscription) send(e Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- e:
+		return
+	default:
+	}
+	switch s.policy {
+	case Block:
+		select {
+		case s.events <- e:
+		case <-s.quit:
+		}
+	case DropOldest:
+		select {
+		case <-s.events:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// bus passes events to subscribers in the order they were emitted
+type bus struct {
+	mtx      sync.Mutex
+	subs     []*Subscription // replaced on every change, so that it can be published to without the lock
+	handlers *handlerQueue   // nil until the first handler is registered
+}
+
+// handlerQueue runs the callbacks registered with Client.on. Its queue is unbounded, so emitting
+// never waits for a callback, not even for one that emits events itself.
+type handlerQueue struct {
+	mtx      sync.Mutex
+	handlers []func(Event)
+	events   []Event
+	ready    chan struct{} // holds a signal while events are queued
+}
+
+func (q *handlerQueue) push(e Event) {
+	q.mtx.Lock()
+	q.events = append(q.events, e)
+	q.mtx.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *handlerQueue) run() {
+	for range q.ready {
+		q.mtx.Lock()
+		events, handlers := q.events, q.handlers
+		q.events = nil
+		q.mtx.Unlock()
+		for _, e := range events {
+			for _, h := range handlers {
+				h(e)
+			}
+		}
+	}
+}
+
+func (b *bus) subscribe(ctx context.Context, opts SubscribeOptions) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+	s := &Subscription{
+		bus:    b,
+		events: make(chan Event, opts.Buffer),
+		policy: opts.Backpressure,
+		quit:   make(chan struct{}),
+	}
+	b.mtx.Lock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], s)
+	b.mtx.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Unsubscribe()
+		case <-s.quit:
+		}
+	}()
+	return s
+}
+
+func (b *bus) remove(s *Subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if sub != s {
+			subs = append(subs, sub)
+		}
+	}
+	b.subs = subs
+}
+
+// handle registers a callback, see Client.on
+func (b *bus) handle(f func(Event)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.handlers == nil {
+		b.handlers = &handlerQueue{ready: make(chan struct{}, 1)}
+		go b.handlers.run()
+	}
+	b.handlers.mtx.Lock()
+	b.handlers.handlers = append(b.handlers.handlers, f)
+	b.handlers.mtx.Unlock()
+}
+
+// publish sends the event outside of the lock, so that a blocking subscriber does not keep others
+// from subscribing or unsubscribing. Events emitted one after another still arrive in that order.
+func (b *bus) publish(e Event) {
+	b.mtx.Lock()
+	subs, handlers := b.subs, b.handlers
+	b.mtx.Unlock()
+	if handlers != nil {
+		handlers.push(e)
+	}
+	for _, s := range subs {
+		s.send(e)
+	}
+}
+
+// Subscribe returns a stream of all events emitted by this Client from now on.
+// The subscription ends when the context is done or Unsubscribe is called.
+func (d *Client) Subscribe(ctx context.Context, opts SubscribeOptions) *Subscription {
+	return d.bus.subscribe(ctx, opts)
+}
+
+// on registers a callback for the events. All callbacks are run on a single goroutine,
+// in the order the events occurred. A slow callback delays the others, but not the client.
+func (d *Client) on(f func(Event)) {
+	d.bus.handle(f)
+}
+
+// OnDownload calls the given hook when a new Download is started. The download object is passed.
+func (d *Client) OnDownload(f func(*Download)) {
+	d.on(func(e Event) {
+		if e, ok := e.(DownloadEvent); ok {
+			f(e.Download)
+		}
+	})
+}
+
+// OnSkip calls the given hook when a download is skipped
+func (d *Client) OnSkip(f func(File)) {
+	d.on(func(e Event) {
+		if e, ok := e.(SkipEvent); ok {
+			f(e.File)
+		}
+	})
+}
+
+// OnError calls the given hook when an error occurred in `Download`
+func (d *Client) OnError(f func(File, error)) {
+	d.on(func(e Event) {
+		if e, ok := e.(ErrorEvent); ok {
+			f(e.File, e.Err)
+		}
+	})
+}
+
+// OnResolve calls the given hook when a resolve job is finished.
+// It passes the original URLs, the File if successful or the error if not.
+func (d *Client) OnResolve(f func(*url.URL, File, error)) {
+	d.on(func(e Event) {
+		if e, ok := e.(ResolveEvent); ok {
+			f(e.URL, e.File, e.Err)
+		}
+	})
+}
+
+// OnDeadend calls the given hook when a file is offline.
+func (d *Client) OnDeadend(f func(*url.URL)) {
+	d.on(func(e Event) {
+		if e, ok := e.(DeadendEvent); ok {
+			f(e.File.URL())
+		}
+	})
+}
+
+// OnComplete calls the given hook when a download finished, whether successfully or not.
+func (d *Client) OnComplete(f func(CompleteEvent)) {
+	d.on(func(e Event) {
+		if e, ok := e.(CompleteEvent); ok {
+			f(e)
+		}
+	})
+}
+
+// OnWait calls the given hook when the provider asked to wait before retrieving a file.
+func (d *Client) OnWait(f func(WaitEvent)) {
+	d.on(func(e Event) {
+		if e, ok := e.(WaitEvent); ok {
+			f(e)
+		}
+	})
+}
+
+// OnCancel calls the given hook when a file is canceled.
+func (d *Client) OnCancel(f func(CancelEvent)) {
+	d.on(func(e Event) {
+		if e, ok := e.(CancelEvent); ok {
+			f(e)
+		}
+	})
+}
+
+// OnContainerDone calls the given hook when all files of a container are finished.
+func (d *Client) OnContainerDone(f func(ContainerDoneEvent)) {
+	d.on(func(e Event) {
+		if e, ok := e.(ContainerDoneEvent); ok {
+			f(e)
+		}
+	})
+}
+
+func completed(dl *Download) CompleteEvent {
+	return CompleteEvent{
+		EventInfo: info(dl.File),
+		Download:  dl,
+		Duration:  dl.Duration(),
+		Bytes:     dl.Bytes(),
+		Speed:     dl.AverageSpeed(),
+		Verified:  dl.verified,
+		Err:       dl.err,
+	}
+}
+
+func containerDone(c *container) ContainerDoneEvent {
+	e := ContainerDoneEvent{
+		EventInfo: EventInfo{Time: time.Now(), Container: c},
+		Status:    c.Status(),
+		Duration:  time.Since(c.created),
+	}
+	for _, f := range c.Files() {
+		if f.Err != nil {
+			e.Errors = append(e.Errors, f.Err)
+		}
+	}
+	e.Bytes = c.transferred()
+	e.Speed = float64(e.Bytes) / e.Duration.Seconds()
+	return e
+}
+
+// info fills in the fields common to all events concerning the given file
+func info(f File) EventInfo {
+	return EventInfo{Time: time.Now(), Container: f.request().container, File: f}
+}
+
+func (d *Client) emit(e Event) {
+	d.bus.publish(e)
+}
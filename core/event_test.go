@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func numbered(i int) Event {
+	return ResolveEvent{URL: &url.URL{Path: strconv.Itoa(i)}}
+}
+
+func number(e Event) int {
+	i, _ := strconv.Atoi(e.(ResolveEvent).URL.Path)
+	return i
+}
+
+func TestSubscribeOrder(t *testing.T) {
+	d := NewClientWith(0)
+	sub := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 1})
+	done := make(chan []int)
+	go func() {
+		var got []int
+		for e := range sub.Events() {
+			got = append(got, number(e))
+		}
+		done <- got
+	}()
+	for i := 0; i < 100; i++ {
+		d.emit(numbered(i))
+	}
+	sub.Unsubscribe()
+	got := <-done
+	assert.Len(t, got, 100)
+	for i, n := range got {
+		assert.Equal(t, i, n)
+	}
+	assert.Equal(t, uint64(0), sub.Dropped())
+}
+
+func TestSubscribeBackpressure(t *testing.T) {
+	d := NewClientWith(0)
+	newest := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 2, Backpressure: DropNewest})
+	oldest := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 2, Backpressure: DropOldest})
+	for i := 0; i < 5; i++ {
+		d.emit(numbered(i))
+	}
+	assert.Equal(t, uint64(3), newest.Dropped())
+	assert.Equal(t, uint64(3), oldest.Dropped())
+	newest.Unsubscribe()
+	oldest.Unsubscribe()
+	var kept []int
+	for e := range newest.Events() {
+		kept = append(kept, number(e))
+	}
+	assert.Equal(t, []int{0, 1}, kept)
+	kept = nil
+	for e := range oldest.Events() {
+		kept = append(kept, number(e))
+	}
+	assert.Equal(t, []int{3, 4}, kept)
+}
+
+func TestSubscribeContext(t *testing.T) {
+	d := NewClientWith(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := d.Subscribe(ctx, SubscribeOptions{Buffer: 1})
+	d.emit(numbered(0))
+	cancel()
+	// the subscription ends although nobody reads it, and does not block the client
+	for range sub.Events() {
+	}
+	d.emit(numbered(1))
+	sub.Unsubscribe()
+}
+
+func TestSubscribeOrderPerFile(t *testing.T) {
+	d := NewClientWith(0)
+	sub := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 1})
+	done := make(chan map[string][]int)
+	go func() {
+		got := make(map[string][]int)
+		for e := range sub.Events() {
+			u := e.(ResolveEvent).URL
+			i, _ := strconv.Atoi(u.Fragment)
+			got[u.Path] = append(got[u.Path], i)
+		}
+		done <- got
+	}()
+	// every file's events are emitted by one goroutine, like the client does
+	var wg sync.WaitGroup
+	for f := 0; f < 4; f++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				d.emit(ResolveEvent{URL: &url.URL{Path: path, Fragment: strconv.Itoa(i)}})
+			}
+		}(strconv.Itoa(f))
+	}
+	wg.Wait()
+	sub.Unsubscribe()
+	got := <-done
+	assert.Len(t, got, 4)
+	for _, numbers := range got {
+		assert.Len(t, numbers, 100)
+		for i, n := range numbers {
+			assert.Equal(t, i, n)
+		}
+	}
+}
+
+func TestUnsubscribeBlocked(t *testing.T) {
+	d := NewClientWith(0)
+	sub := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 1})
+	d.emit(numbered(0))
+	sent := make(chan struct{})
+	go func() {
+		d.emit(numbered(1))
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("sent to a full subscription")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// others can (un)subscribe meanwhile
+	d.Subscribe(context.Background(), SubscribeOptions{}).Unsubscribe()
+	sub.Unsubscribe()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send still blocked after unsubscribing")
+	}
+	var got []int
+	for e := range sub.Events() {
+		got = append(got, number(e))
+	}
+	assert.Equal(t, []int{0}, got)
+}
+
+func TestHandlersDoNotBlock(t *testing.T) {
+	d := NewClientWith(0)
+	release := make(chan struct{})
+	handled := make(chan int, 10000)
+	d.OnResolve(func(u *url.URL, f File, err error) {
+		i, _ := strconv.Atoi(u.Path)
+		if i == 0 {
+			// a callback emitting more events than fit into any buffer
+			for j := 1; j <= 2000; j++ {
+				d.emit(numbered(j))
+			}
+			<-release
+		}
+		handled <- i
+	})
+	d.emit(numbered(0))
+	// neither the client nor the callback itself wait for the callback
+	for i := 2001; i <= 4000; i++ {
+		d.emit(numbered(i))
+	}
+	close(release)
+	for i := 0; i <= 4000; i++ {
+		select {
+		case n := <-handled:
+			if i == 0 {
+				assert.Equal(t, 0, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d events handled", i)
+		}
+	}
+}
+
+func TestCompletionEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := newTestServer(map[string]string{"/a": "hello", "/b": "world"})
+	defer s.Close()
+	d := NewClientWith(1)
+	d.Directory = dir
+	d.Providers = Providers{&serverProvider{s}}
+	sub := d.Subscribe(context.Background(), SubscribeOptions{Buffer: 64})
+	a := d.AddURLs([]*url.URL{s.link("/a")})
+	b := d.AddURLs([]*url.URL{s.link("/b")})
+	b.Cancel()
+	d.Start()
+	a.Wait()
+	b.Wait()
+	var completed []CompleteEvent
+	done := make(map[Container]ContainerDoneEvent)
+	for len(done) < 2 {
+		select {
+		case e := <-sub.Events():
+			switch e := e.(type) {
+			case CompleteEvent:
+				completed = append(completed, e)
+			case ContainerDoneEvent:
+				done[e.Container] = e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("containers not done")
+		}
+	}
+	if assert.Len(t, completed, 1) {
+		assert.Equal(t, "a", completed[0].File.Name())
+		assert.Equal(t, int64(5), completed[0].Bytes)
+		assert.NoError(t, completed[0].Err)
+	}
+	assert.Equal(t, 1, done[a].Status.Done)
+	assert.Equal(t, int64(5), done[a].Bytes)
+	assert.Empty(t, done[a].Errors)
+	assert.True(t, done[b].Status.Stopped)
+	assert.Equal(t, int64(0), done[b].Bytes)
+	assert.Equal(t, 0, s.requests("/b"))
+}
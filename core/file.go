@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/uget/uget/core/api"
@@ -15,8 +16,8 @@ import (
 // For any given File, the order of method calls must be:
 //     1. `Err()` - if this returns `nil`, continue with checking the file's availability:
 //     2. `Offline()` - and if this also returns false, the file is valid and available.
-// If `Err()` returns an error, `Offline()` and all non-URL methods will panic.
-// Same for when `Offline()` returns `true`.
+// If `Err()` returns an error, `Offline()` and all methods but `URL()` and `Name()` will panic.
+// Same for when `Offline()` returns `true`. `Name()` then falls back to the last segment of the URL.
 type File interface {
 	api.File
 
@@ -40,27 +41,41 @@ type File interface {
 	// OriginalURL returns the original URL (passed to Client) that ultimately yielded this File.
 	OriginalURL() *url.URL
 
+	// CanonicalURL returns the canonical form of OriginalURL, after the rewrite rules and providers were applied.
+	CanonicalURL() *url.URL
+
 	// done callback when this file is done downloading.
 	// also ensures File is not implemented outside this package.
 	done()
+
+	// request returns the request that resolved to this file.
+	request() *request
 }
 
 var _ File = onlineFile{}
 var _ File = offlineFile{}
 var _ File = erroredFile{}
+var _ File = unresolvedFile{}
+
+func online(f api.File, r *request) File { return onlineFile{file{f, r}} }
 
-func online(f api.File, orig *url.URL, done func()) File { return onlineFile{file{f, orig}, done} }
+func offline(r *request, curr *url.URL) File { return offlineFile{file{nil, r}, curr} }
 
-func offline(orig, curr *url.URL) File { return offlineFile{file{nil, orig}, curr} }
+func errored(r *request, curr *url.URL, err error) File { return erroredFile{file{nil, r}, curr, err} }
 
-func errored(orig, curr *url.URL, err error) File { return erroredFile{file{nil, orig}, curr, err} }
+func unresolved(r *request) File { return unresolvedFile{file{nil, r}} }
 
 type file struct {
 	api.File
-	original *url.URL
+	req *request
 }
 
-func (f file) OriginalURL() *url.URL { return f.original }
+func (f file) CanonicalURL() *url.URL { return f.req.root().u }
+func (f file) request() *request      { return f.req }
+func (f file) OriginalURL() *url.URL {
+	root := f.req.root()
+	return root.container.raw[root.key[0]]
+}
 func (f file) ID() string {
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(f.URL().String())))
 }
@@ -81,13 +96,12 @@ func (f file) MarshalJSON() ([]byte, error) {
 
 type onlineFile struct {
 	file
-	donecb func()
 }
 
 func (f onlineFile) Err() error          { return nil }
 func (f onlineFile) Offline() bool       { return false }
 func (f onlineFile) LengthUnknown() bool { return f.Size() == api.FileSizeUnknown }
-func (f onlineFile) done()               { f.donecb() }
+func (f onlineFile) done()               { f.req.done() }
 
 type offlineFile struct {
 	file
@@ -99,6 +113,7 @@ func (f offlineFile) Offline() bool       { return true }
 func (f offlineFile) LengthUnknown() bool { panic("LengthUnknown() on offline file") }
 func (f offlineFile) done()               { panic("done() on offline file") }
 func (f offlineFile) URL() *url.URL       { return f.u }
+func (f offlineFile) Name() string        { return nameOf(f.u) }
 
 type erroredFile struct {
 	file
@@ -111,3 +126,23 @@ func (f erroredFile) Offline() bool       { panic("Offline() on errored file") }
 func (f erroredFile) LengthUnknown() bool { panic("LengthUnknown() on errored file") }
 func (f erroredFile) done()               { panic("done() on errored file") }
 func (f erroredFile) URL() *url.URL       { return f.u }
+func (f erroredFile) Name() string        { return nameOf(f.u) }
+
+// unresolvedFile stands for a request while it waits to be resolved again
+type unresolvedFile struct {
+	file
+}
+
+func (f unresolvedFile) Err() error          { return nil }
+func (f unresolvedFile) Offline() bool       { return false }
+func (f unresolvedFile) LengthUnknown() bool { return true }
+func (f unresolvedFile) done()               { panic("done() on unresolved file") }
+func (f unresolvedFile) URL() *url.URL       { return f.req.u }
+func (f unresolvedFile) Name() string        { return nameOf(f.req.u) }
+
+func nameOf(u *url.URL) string {
+	if name := path.Base(u.Path); name != "/" && name != "." {
+		return name
+	}
+	return u.Host
+}
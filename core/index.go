@@ -0,0 +1,85 @@
+package core
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// providerIndex finds the providers that may handle a URL by its host, see api.Hosted.
+type providerIndex struct {
+	providers Providers        // the indexed providers
+	hosts     map[string][]int // positions of the hosted providers, by host or `*.`-pattern
+	unhosted  []int            // positions of the providers that handle any host
+	position  map[Provider]int
+}
+
+func (d *Client) newProviderIndex(ps Providers) *providerIndex {
+	idx := &providerIndex{providers: ps, hosts: make(map[string][]int), position: make(map[Provider]int, len(ps))}
+	for i, p := range ps {
+		idx.position[p] = i
+		var hosts []string
+		if h, ok := p.(Hosted); ok {
+			d.safely(p, "Hosts", func() error {
+				hosts = h.Hosts()
+				return nil
+			})
+		}
+		if len(hosts) == 0 {
+			idx.unhosted = append(idx.unhosted, i)
+			continue
+		}
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			idx.hosts[host] = append(idx.hosts[host], i)
+		}
+	}
+	return idx
+}
+
+// lookup returns the providers that may handle the URL, in their original order.
+// also is a provider to include in any case, e.g. the one that resolved a file. It can be nil.
+func (idx *providerIndex) lookup(u *url.URL, also Provider) Providers {
+	host := strings.ToLower(u.Hostname())
+	positions := append([]int(nil), idx.unhosted...)
+	positions = append(positions, idx.hosts[host]...)
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		positions = append(positions, idx.hosts["*."+host]...)
+	}
+	if i, ok := idx.position[also]; ok {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+	ps := make(Providers, 0, len(positions))
+	for i, pos := range positions {
+		// a provider may match several of its hosts
+		if i == 0 || pos != positions[i-1] {
+			ps = append(ps, idx.providers[pos])
+		}
+	}
+	return ps
+}
+
+// index returns the index of the client's providers, rebuilding it if they changed.
+func (d *Client) index() *providerIndex {
+	d.indexMtx.Lock()
+	defer d.indexMtx.Unlock()
+	if d.idx == nil || !same(d.idx.providers, d.Providers) {
+		d.idx = d.newProviderIndex(d.Providers)
+	}
+	return d.idx
+}
+
+// same returns whether both slices share the same providers, in the same order.
+func same(a, b Providers) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+// hostProvider resolves and retrieves the URLs of a single host, but does not declare it.
+type hostProvider struct{ host string }
+
+func (p *hostProvider) Name() string { return p.host }
+func (p *hostProvider) CanResolve(u *url.URL) api.Resolvability {
+	if u.Hostname() == p.host {
+		return api.Single
+	}
+	return api.Next
+}
+func (p *hostProvider) ResolveOne(r api.Request) ([]api.Request, error) { return nil, nil }
+func (p *hostProvider) CanRetrieve(f api.File) uint {
+	if f.URL().Hostname() == p.host {
+		return 1
+	}
+	return 0
+}
+func (p *hostProvider) Retrieve(f api.File) (*http.Request, error) { return nil, nil }
+
+// hostedProvider is a hostProvider that declares its host.
+type hostedProvider struct{ hostProvider }
+
+func (p *hostedProvider) Hosts() []string { return []string{p.host, "*." + p.host} }
+
+type testFile struct {
+	u *url.URL
+	p api.Provider
+}
+
+func (f testFile) URL() *url.URL                         { return f.u }
+func (f testFile) Size() int64                           { return 0 }
+func (f testFile) Name() string                          { return f.u.Path }
+func (f testFile) Checksum() ([]byte, string, hash.Hash) { return nil, "", nil }
+func (f testFile) Provider() api.Provider                { return f.p }
+
+func TestProviderIndex(t *testing.T) {
+	any := &hostProvider{"any"}
+	a := &hostedProvider{hostProvider{"a.com"}}
+	b := &hostedProvider{hostProvider{"b.com"}}
+	idx := NewClientWith(0).newProviderIndex(Providers{a, any, b})
+	lookup := func(raw string, also Provider) []string {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		return names(idx.lookup(u, also))
+	}
+	assert.Equal(t, []string{"a.com", "any"}, lookup("http://a.com/x", nil))
+	assert.Equal(t, []string{"a.com", "any"}, lookup("http://dl.eu.A.com:8080/x", nil))
+	assert.Equal(t, []string{"any", "b.com"}, lookup("http://b.com/x", nil))
+	assert.Equal(t, []string{"any"}, lookup("http://c.com/x", nil))
+	assert.Equal(t, []string{"any", "b.com"}, lookup("http://cdn.net/x", b))
+}
+
+func benchProviders(n int, hosted bool) Providers {
+	ps := make(Providers, n)
+	for i := range ps {
+		p := hostProvider{fmt.Sprintf("host%d.com", i)}
+		if hosted {
+			ps[i] = &hostedProvider{p}
+		} else {
+			ps[i] = &p
+		}
+	}
+	return ps
+}
+
+func benchmarkResolvability(b *testing.B, hosted bool) {
+	d := NewClientWith(0)
+	d.Providers = benchProviders(500, hosted)
+	r := testRequest("/file")
+	r.u.Host = "host499.com"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ablty := d.resolvability(r); ablty != api.Single {
+			b.Fatal("not resolvable")
+		}
+	}
+}
+
+func BenchmarkResolvabilityLinear(b *testing.B)  { benchmarkResolvability(b, false) }
+func BenchmarkResolvabilityIndexed(b *testing.B) { benchmarkResolvability(b, true) }
+
+func benchmarkRetriever(b *testing.B, hosted bool) {
+	d := NewClientWith(0)
+	d.Providers = benchProviders(500, hosted)
+	r := testRequest("/file")
+	file := online(testFile{&url.URL{Scheme: "http", Host: "host499.com", Path: "/file"}, d.Providers[499]}, r)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if d.retriever(file, nil) == nil {
+			b.Fatal("not retrievable")
+		}
+	}
+}
+
+func BenchmarkRetrieverLinear(b *testing.B)  { benchmarkRetriever(b, false) }
+func BenchmarkRetrieverIndexed(b *testing.B) { benchmarkRetriever(b, true) }
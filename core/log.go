@@ -0,0 +1,51 @@
+package core
+
+// Fields annotate log messages, e.g. with the container, file or provider they concern.
+type Fields map[string]interface{}
+
+// Logger receives the messages of the client. Set Client.Log to route them, they are dropped otherwise.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// WithFields returns a Logger that annotates its messages with the given fields as well.
+	WithFields(Fields) Logger
+}
+
+// NopLogger drops all messages. It is the default of library users.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+func (l nopLogger) WithFields(Fields) Logger    { return l }
+
+// logger returns the Logger of the client, NopLogger if there is none.
+func (d *Client) logger() Logger {
+	if d.Log == nil {
+		return NopLogger
+	}
+	return d.Log
+}
+
+// fileLogger annotates the messages with the file and its container.
+func (d *Client) fileLogger(file File) Logger {
+	if d.Log == nil {
+		// not worth building the fields
+		return NopLogger
+	}
+	return d.Log.WithFields(Fields{
+		"container": file.request().container.name(),
+		"file":      file.Name(),
+	})
+}
+
+// providerLogger annotates the messages with the provider.
+func (d *Client) providerLogger(p Provider) Logger {
+	return d.logger().WithFields(Fields{"provider": p.Name()})
+}
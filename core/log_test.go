@@ -0,0 +1,62 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLogger keeps the messages it receives, prefixed with their fields.
+type recordingLogger struct {
+	fields   Fields
+	messages *[]string
+}
+
+func (l recordingLogger) logf(format string, args ...interface{}) {
+	*l.messages = append(*l.messages, fmt.Sprintf("%v %s", l.fields, fmt.Sprintf(format, args...)))
+}
+
+func (l recordingLogger) Debugf(format string, args ...interface{}) { l.logf(format, args...) }
+func (l recordingLogger) Infof(format string, args ...interface{})  { l.logf(format, args...) }
+func (l recordingLogger) Warnf(format string, args ...interface{})  { l.logf(format, args...) }
+func (l recordingLogger) Errorf(format string, args ...interface{}) { l.logf(format, args...) }
+
+func (l recordingLogger) WithFields(fields Fields) Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return recordingLogger{merged, l.messages}
+}
+
+func TestClientLog(t *testing.T) {
+	d := NewClientWith(0)
+	d.Providers = Providers{namedProvider("a")}
+	// without a Logger, messages are dropped
+	d.UseProviderConfig(ProviderConfig{Order: []string{"missing"}})
+	var messages []string
+	d.Log = recordingLogger{messages: &messages}
+	d.UseProviderConfig(ProviderConfig{Order: []string{"missing"}})
+	assert.Equal(t, []string{"map[] Client#UseProviderConfig: no provider named missing"}, messages)
+	messages = nil
+	d.safely(namedProvider("a"), "Name", func() error { panic("boom") })
+	assert.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "map[provider:a] a#Name panicked: boom")
+}
+
+func TestFileLogger(t *testing.T) {
+	d := NewClientWith(0)
+	c, roots := testContainer(1, 0)
+	file := roots[0].ResolvesTo(testFile{roots[0].u, nil}).(*request).file
+	assert.Equal(t, NopLogger, d.fileLogger(file))
+	var messages []string
+	d.Log = recordingLogger{messages: &messages}
+	d.fileLogger(file).Infof("hello")
+	d.fileLogger(file).Infof("again")
+	fields := fmt.Sprintf("map[container:%v file:/0]", c.ID())
+	assert.Equal(t, []string{fields + " hello", fields + " again"}, messages)
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+// testServer serves files by path. A path answers with the failing status
+// as often as its failures say, before it is served.
+type testServer struct {
+	*httptest.Server
+	mtx      sync.Mutex
+	files    map[string]string
+	failures map[string]int
+	failing  int // status code of failures
+	hits     map[string]int
+}
+
+func newTestServer(files map[string]string) *testServer {
+	s := &testServer{
+		files:    files,
+		failures: make(map[string]int),
+		failing:  http.StatusServiceUnavailable,
+		hits:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		s.hits[r.URL.Path]++
+		content, ok := s.files[r.URL.Path]
+		failing := s.failures[r.URL.Path] > 0
+		if failing {
+			s.failures[r.URL.Path]--
+		}
+		status := s.failing
+		s.mtx.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+		} else if failing {
+			http.Error(w, http.StatusText(status), status)
+		} else {
+			w.Write([]byte(content))
+		}
+	}))
+	return s
+}
+
+func (s *testServer) link(path string) *url.URL {
+	u, _ := url.Parse(s.URL + path)
+	return u
+}
+
+func (s *testServer) requests(path string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.hits[path]
+}
+
+type serverFile struct {
+	u    *url.URL
+	size int64
+	p    api.Provider
+}
+
+func (f serverFile) URL() *url.URL                         { return f.u }
+func (f serverFile) Size() int64                           { return f.size }
+func (f serverFile) Name() string                          { return path.Base(f.u.Path) }
+func (f serverFile) Checksum() ([]byte, string, hash.Hash) { return nil, "", nil }
+func (f serverFile) Provider() api.Provider                { return f.p }
+
+// serverProvider resolves the links of a testServer and retrieves its files.
+type serverProvider struct{ s *testServer }
+
+func (p *serverProvider) Name() string { return "server" }
+
+func (p *serverProvider) CanResolve(u *url.URL) api.Resolvability {
+	if u.Host == p.s.Listener.Addr().String() {
+		return api.Single
+	}
+	return api.Next
+}
+
+func (p *serverProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	content, ok := p.s.files[r.URL().Path]
+	if !ok {
+		return []api.Request{r.Deadend(nil)}, nil
+	}
+	return r.ResolvesTo(serverFile{r.URL(), int64(len(content)), p}).Wrap(), nil
+}
+
+func (p *serverProvider) CanRetrieve(f api.File) uint {
+	if f.URL().Host == p.s.Listener.Addr().String() {
+		return 1
+	}
+	return 0
+}
+
+func (p *serverProvider) Retrieve(f api.File) (*http.Request, error) {
+	return http.NewRequest("GET", f.URL().String(), nil)
+}
+
+func TestContainerOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := newTestServer(map[string]string{"/a.txt": "aaa", "/b.bin": "bbb"})
+	defer s.Close()
+	s.failures["/a.txt"] = 1
+	d := NewClientWith(1)
+	d.Providers = Providers{&serverProvider{s}}
+	c := d.AddURLsWith([]*url.URL{s.link("/a.txt"), s.link("/b.bin")}, ContainerOptions{
+		Directory: filepath.Join(dir, "sub"),
+		Filter:    Filter{Include: []string{"*.txt"}},
+		Retry:     RetryPolicy{Attempts: 1},
+	})
+	d.Start()
+	c.Wait()
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "sub", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "aaa", string(bs))
+	assert.Equal(t, 2, s.requests("/a.txt"))
+	// filtered
+	assert.Equal(t, 0, s.requests("/b.bin"))
+	_, err = os.Stat(filepath.Join(dir, "sub", "b.bin"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFilterMatch(t *testing.T) {
+	file := func(name string, size int64) File {
+		return online(serverFile{&url.URL{Path: "/" + name}, size, nil}, nil)
+	}
+	f := Filter{Include: []string{"*.mkv", "*.mp4"}, Exclude: []string{"sample*"}, MinSize: 10, MaxSize: 100}
+	assert.True(t, f.Match(file("movie.mkv", 50)))
+	assert.False(t, f.Match(file("movie.avi", 50)))
+	assert.False(t, f.Match(file("sample.mkv", 50)))
+	assert.False(t, f.Match(file("movie.mp4", 5)))
+	assert.False(t, f.Match(file("movie.mp4", 500)))
+	// size limits do not apply to files of unknown length
+	assert.True(t, f.Match(file("movie.mp4", api.FileSizeUnknown)))
+	assert.True(t, Filter{}.Match(file("anything", 0)))
+}
@@ -0,0 +1,70 @@
+package core
+
+// ProviderConfig orders, disables and adjusts providers. The order decides which provider
+// resolves a link if several can.
+type ProviderConfig struct {
+	Order     []string                   `json:"order,omitempty"`     // names of the providers that come first, in this order
+	Providers map[string]ProviderSetting `json:"providers,omitempty"` // by provider name
+	Rewrites  []RewriteRule              `json:"rewrites,omitempty"`  // applied to links before the providers see them
+}
+
+// ProviderSetting adjusts a single provider.
+type ProviderSetting struct {
+	Disabled bool `json:"disabled,omitempty"`
+	Bias     int  `json:"bias,omitempty"` // added to the priority of the provider's CanRetrieve, if it can retrieve a file at all
+}
+
+// Apply returns the enabled providers in the configured order.
+// Providers that the order does not mention follow in their original order, names of unknown providers are ignored.
+func (c ProviderConfig) Apply(ps Providers) Providers {
+	ordered := make(Providers, 0, len(ps))
+	taken := make(map[string]bool)
+	for _, name := range c.Order {
+		if p := ps.GetProvider(name); p != nil && !taken[name] {
+			ordered = append(ordered, p)
+			taken[name] = true
+		}
+	}
+	for _, p := range ps {
+		if !taken[p.Name()] {
+			ordered = append(ordered, p)
+		}
+	}
+	enabled := ordered[:0]
+	for _, p := range ordered {
+		if !c.Providers[p.Name()].Disabled {
+			enabled = append(enabled, p)
+		}
+	}
+	return enabled
+}
+
+// UseProviderConfig applies the config to the providers of this client. Call it before Start.
+func (d *Client) UseProviderConfig(c ProviderConfig) {
+	for _, name := range c.Order {
+		if d.Providers.GetProvider(name) == nil {
+			d.logger().Warnf("Client#UseProviderConfig: no provider named %s", name)
+		}
+	}
+	d.Providers = c.Apply(d.Providers)
+	d.Rewrites = append(d.Rewrites, c.Rewrites...)
+	d.Bias = make(map[string]int)
+	for name, setting := range c.Providers {
+		if setting.Bias != 0 {
+			d.Bias[name] = setting.Bias
+		}
+	}
+}
+
+// biased adds the configured bias of the provider to its CanRetrieve priority.
+// A provider keeps a priority of at least 1 if it can retrieve the file.
+func (d *Client) biased(p Provider, prio uint) uint {
+	bias := d.Bias[p.Name()]
+	if prio == 0 || bias == 0 {
+		return prio
+	}
+	if biased := int(prio) + bias; biased > 0 {
+		return uint(biased)
+	}
+	return 1
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+type namedProvider string
+
+func (p namedProvider) Name() string                { return string(p) }
+func (p namedProvider) CanRetrieve(f api.File) uint { return 0 }
+
+func names(ps Providers) []string {
+	ns := make([]string, len(ps))
+	for i, p := range ps {
+		ns[i] = p.Name()
+	}
+	return ns
+}
+
+func TestProviderConfigApply(t *testing.T) {
+	ps := Providers{namedProvider("a"), namedProvider("b"), namedProvider("c"), namedProvider("d")}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(ProviderConfig{}.Apply(ps)))
+	cfg := ProviderConfig{
+		Order:     []string{"c", "missing", "a"},
+		Providers: map[string]ProviderSetting{"b": {Disabled: true}},
+	}
+	assert.Equal(t, []string{"c", "a", "d"}, names(cfg.Apply(ps)))
+	// the registered providers stay intact
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(ps))
+}
+
+func TestClientBiased(t *testing.T) {
+	d := NewClientWith(0)
+	d.Providers = Providers{namedProvider("a"), namedProvider("b")}
+	d.UseProviderConfig(ProviderConfig{Providers: map[string]ProviderSetting{"a": {Bias: 5}, "b": {Bias: -5}}})
+	assert.Equal(t, uint(7), d.biased(namedProvider("a"), 2))
+	assert.Equal(t, uint(0), d.biased(namedProvider("a"), 0))
+	assert.Equal(t, uint(1), d.biased(namedProvider("b"), 2))
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"container/heap"
+	"math"
+	"sort"
 
 	"github.com/uget/uget/utils"
 )
@@ -19,7 +21,10 @@ type queue struct {
 	*pQueue
 	get       chan File
 	getAll    chan []*request
+	policy    SchedulePolicy
 	finalized bool
+	batch     int        // if > 0, requests are handed out through getAll only, at most batch at once
+	head      []*request // the next batch, taken from the heap already
 }
 
 func (q *queue) Dequeue() <-chan File {
@@ -29,21 +34,26 @@ func (q *queue) Dequeue() <-chan File {
 func (q *queue) List() []File {
 	var pq pQueue
 	<-q.Job(func() {
-		pq = make(pQueue, q.Len())
-		copy(pq, *q.pQueue)
+		pq = make(pQueue, 0, len(q.head)+q.Len())
+		pq = append(append(pq, q.head...), *q.pQueue...)
 	})
+	sort.Sort(pq)
 	cjs := make([]File, pq.Len())
-	i := 0
-	for pq.Len() > 0 {
-		cjs[i] = pq.peek().file
-		heap.Pop(&pq)
-		i++
+	for i, req := range pq {
+		cjs[i] = req.file
 	}
 	return cjs
 }
 
 func (q *queue) Set(f File, prio int) {
 	q.Job(func() {
+		for _, item := range q.head {
+			if item.file == f {
+				// the order of the next batch is settled already
+				item.prio = prio
+				return
+			}
+		}
 		for index, item := range *q.pQueue {
 			if item.file == f {
 				item.prio = prio
@@ -61,8 +71,18 @@ func (q *queue) Remove(id string) <-chan File {
 	fchan := make(chan File, 1)
 	q.Job(func() {
 		defer close(fchan)
+		for index, item := range q.head {
+			if item.file != nil && item.file.ID() == id {
+				q.head = append(q.head[:index], q.head[index+1:]...)
+				if len(q.head) == 0 {
+					q.head = nil
+				}
+				fchan <- item.file
+				return
+			}
+		}
 		for index, item := range *q.pQueue {
-			if item.file.ID() == id {
+			if item.file != nil && item.file.ID() == id {
 				heap.Remove(q, index)
 				fchan <- item.file
 				return
@@ -73,15 +93,18 @@ func (q *queue) Remove(id string) <-chan File {
 }
 
 func newQueue() *queue {
+	return newBatchQueue(0)
+}
+
+// newBatchQueue creates a queue that hands out up to batch requests at once through getAll.
+func newBatchQueue(batch int) *queue {
 	pq := make(pQueue, 0, 31)
-	get := make(chan File)
-	getAll := make(chan []*request)
 	q := &queue{
-		utils.NewJobber(),
-		&pq,
-		get,
-		getAll,
-		false,
+		Jobber: utils.NewJobber(),
+		pQueue: &pq,
+		get:    make(chan File),
+		getAll: make(chan []*request),
+		batch:  batch,
 	}
 	go q.dispatch()
 	return q
@@ -95,48 +118,90 @@ func (q *queue) Finalize() <-chan struct{} {
 	})
 }
 
+// schedule sets the policy which ranks files subsequently added to this queue.
+func (q *queue) schedule(p SchedulePolicy) <-chan struct{} {
+	return q.Job(func() {
+		q.policy = p
+	})
+}
+
 func (q *queue) enqueue(req *request) <-chan struct{} {
 	return q.Job(func() {
-		heap.Push(q, req)
+		q.push(req)
 	})
 }
 
 func (q *queue) enqueueAll(reqs []*request) <-chan struct{} {
 	return q.Job(func() {
 		for _, req := range reqs {
-			heap.Push(q, req)
+			q.push(req)
 		}
 	})
 }
 
 // == private methods, not to be used from outside ==
 
+func (q *queue) push(req *request) {
+	if q.policy != nil && req.resolved() {
+		if req.file.Err() != nil || req.file.Offline() {
+			// unavailable files need no retriever, so get them out of the way.
+			req.rank = math.MinInt64
+		} else {
+			req.rank = q.policy.Rank(req.file, req.container)
+		}
+	}
+	heap.Push(q, req)
+}
+
 func (q *queue) dispatch() {
 	for {
-		if q.Len() > 0 {
-			select {
-			case q.getAll <- *q.pQueue:
-				pq := make(pQueue, 0)
-				q.pQueue = &pq
-			case q.get <- q.peek().file:
-				// fmt.Printf("q#pop, prio %v, url %v\n", q.peek().prio, q.peek().u)
-				heap.Pop(q)
-			case job := <-q.JobQueue:
-				job.Work()
-				close(job.Done)
+		// nil channels are never ready, which disables their case.
+		var get chan File
+		var getAll chan []*request
+		var next File
+		if q.batch > 0 {
+			if q.head == nil && q.Len() > 0 {
+				q.head = q.take(q.batch)
+			}
+			if q.head != nil {
+				getAll = q.getAll
 			}
-		} else if q.finalized {
+		} else if q.Len() > 0 {
+			get, next = q.get, q.peek().file
+		}
+		if get == nil && getAll == nil && q.finalized {
 			close(q.get)
 			close(q.getAll)
 			return
-		} else {
-			job := <-q.JobQueue
+		}
+		select {
+		case getAll <- q.head:
+			q.head = nil
+		case get <- next:
+			heap.Pop(q)
+		case job := <-q.JobQueue:
 			job.Work()
 			close(job.Done)
 		}
 	}
 }
 
+// take pops the first n requests off the heap, or all of them if there are fewer.
+func (q *queue) take(n int) []*request {
+	if n >= q.Len() {
+		reqs := *q.pQueue
+		pq := make(pQueue, 0, 31)
+		q.pQueue = &pq
+		return reqs
+	}
+	reqs := make([]*request, n)
+	for i := range reqs {
+		reqs[i] = q.peek()
+		heap.Pop(q)
+	}
+	return reqs
+}
+
 type pQueue []*request
 
 func (pq pQueue) Len() int {
@@ -144,6 +209,9 @@ func (pq pQueue) Len() int {
 }
 
 func (pq pQueue) Less(i, j int) bool {
+	if pq[i].rank != pq[j].rank {
+		return pq[i].rank < pq[j].rank
+	}
 	return pq[i].less(pq[j])
 }
 
@@ -156,7 +224,10 @@ func (pq *pQueue) Push(x interface{}) {
 }
 
 func (pq *pQueue) Pop() interface{} {
-	*pq = (*pq)[0 : len(*pq)-1]
+	old := *pq
+	// do not keep the popped request reachable through the backing array
+	old[len(old)-1] = nil
+	*pq = old[0 : len(old)-1]
 	return nil
 }
 
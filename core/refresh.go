@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uget/uget/core/api"
+)
+
+// expiryMargin is how long before its expiry a link is considered expired,
+// so that it does not die right after the download started.
+const expiryMargin = 30 * time.Second
+
+// maxRefreshSteps limits the resolutions refresh performs for a single file.
+const maxRefreshSteps = 10
+
+// expired returns whether the link of the file has expired, or is about to.
+func expired(f File) bool {
+	of, ok := f.(onlineFile)
+	if !ok {
+		return false
+	}
+	e, ok := of.File.(api.Expiring)
+	return ok && !e.Expires().IsZero() && time.Now().Add(expiryMargin).After(e.Expires())
+}
+
+// rejected returns whether the error means the server refused the link, e.g. because it expired.
+func rejected(err error) bool {
+	se, ok := err.(StatusError)
+	return ok && (se.Code == http.StatusForbidden || se.Code == http.StatusGone)
+}
+
+// refresh resolves the request that led to the file once more, to obtain a fresh link.
+// It must resolve to a single file again.
+func (d *Client) refresh(f File) (File, error) {
+	p := f.request()
+	// resolve in a scratch container, so that the accounting of the actual one stays intact.
+	scratch := &container{opts: p.container.opts, ctx: p.container.ctx, wg: new(sync.WaitGroup)}
+	scratch.wg.Add(1)
+	r := &request{container: scratch, parent: p.parent, u: p.u, key: p.key, prio: p.prio}
+	// follow redirections to other providers, if any
+	for i := 0; !r.resolved(); i++ {
+		if i == maxRefreshSteps {
+			return nil, fmt.Errorf("%v did not resolve in %d steps", p.u, maxRefreshSteps)
+		}
+		var reqs []api.Request
+		resolver, ablty := d.resolvability(r)
+		if ablty == api.Next {
+			return nil, d.noResolver(r)
+		}
+		// within the provider's limits, like any other resolution
+		release := d.throttle(resolver)
+		ctx, cancel := d.callContext(scratch.ctx)
+		r.ctx = ctx
+		err := d.safely(resolver, "Resolve", func() (err error) {
+			if sr := singleResolver(resolver); sr != nil && ablty == api.Single {
+				reqs, err = sr.ResolveOneContext(ctx, r)
+			} else {
+				reqs, err = multiResolver(resolver).ResolveManyContext(ctx, r.Wrap())
+			}
+			return err
+		})
+		cancel()
+		release()
+		if err != nil {
+			return nil, timedOut(err)
+		}
+		if len(reqs) != 1 {
+			return nil, fmt.Errorf("%v did not resolve to a single file", p.u)
+		}
+		r = reqs[0].(*request)
+	}
+	resolved := r.file
+	if resolved.Err() != nil {
+		return nil, resolved.Err()
+	} else if resolved.Offline() {
+		return nil, fmt.Errorf("%v went offline", p.u)
+	}
+	fresh := online(resolved.(onlineFile).File, p)
+	p.container.relink(fresh)
+	return fresh, nil
+}
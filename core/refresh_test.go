@@ -0,0 +1,112 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+type expiringFile struct {
+	serverFile
+	expires time.Time
+}
+
+func (f expiringFile) Expires() time.Time { return f.expires }
+
+// refreshingProvider hands out links that expire right away when they are first resolved.
+type refreshingProvider struct {
+	serverProvider
+	mtx      sync.Mutex
+	resolved map[string]int
+}
+
+func (p *refreshingProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	p.mtx.Lock()
+	p.resolved[r.URL().Path]++
+	n := p.resolved[r.URL().Path]
+	p.mtx.Unlock()
+	f := expiringFile{serverFile{r.URL(), int64(len(p.s.files[r.URL().Path])), p}, time.Now().Add(time.Hour)}
+	if n == 1 {
+		f.expires = time.Now()
+	}
+	return r.ResolvesTo(f).Wrap(), nil
+}
+
+func (p *refreshingProvider) resolutions(path string) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.resolved[path]
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := newTestServer(map[string]string{"/expired": "aaa", "/rejected": "bbb"})
+	defer s.Close()
+	s.failures["/rejected"] = 1
+	s.failing = http.StatusGone
+	p := &refreshingProvider{serverProvider: serverProvider{s}, resolved: make(map[string]int)}
+	d := NewClientWith(1)
+	d.Directory = dir
+	d.Providers = Providers{p}
+	c := d.AddURLs([]*url.URL{s.link("/expired")})
+	d.Start()
+	c.Wait()
+	// the expired link is resolved again before it is retrieved
+	assert.Equal(t, 2, p.resolutions("/expired"))
+	assert.Equal(t, 1, s.requests("/expired"))
+
+	// the rejected one once more after the server refused it (its first link expired as well),
+	// and it is retried although there are no attempts left
+	c = d.AddURLs([]*url.URL{s.link("/rejected")})
+	c.Wait()
+	assert.Equal(t, 3, p.resolutions("/rejected"))
+	assert.Equal(t, 2, s.requests("/rejected"))
+	for _, name := range []string{"expired", "rejected"} {
+		_, err = os.Stat(dir + "/" + name)
+		assert.NoError(t, err)
+	}
+}
+
+// throttledProvider counts the resolutions that did not take a slot of the client's resolver pool
+type throttledProvider struct {
+	*refreshingProvider
+	d           *Client
+	unthrottled int32
+}
+
+func (p *throttledProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	if len(p.d.resolving) == 0 {
+		atomic.AddInt32(&p.unthrottled, 1)
+	}
+	return p.refreshingProvider.ResolveOne(r)
+}
+
+func TestRefreshThrottled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := newTestServer(map[string]string{"/expired": "aaa"})
+	defer s.Close()
+	d := NewClientWith(1)
+	d.Directory = dir
+	p := &throttledProvider{
+		refreshingProvider: &refreshingProvider{serverProvider: serverProvider{s}, resolved: make(map[string]int)},
+		d:                  d,
+	}
+	d.Providers = Providers{p}
+	c := d.AddURLs([]*url.URL{s.link("/expired")})
+	d.Start()
+	c.Wait()
+	assert.Equal(t, 2, p.resolutions("/expired"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&p.unthrottled))
+}
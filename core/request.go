@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"net/url"
 
 	"github.com/uget/uget/core/api"
@@ -10,53 +11,55 @@ type request struct {
 	container *container
 	parent    *request
 	u         *url.URL
-	order     int
+	key       []int32 // position in the container: the order of the root, then the order among its siblings per level
 	prio      int
+	rank      int64 // assigned by the queue's SchedulePolicy
+	attempts  int   // failed resolutions that were retried
+	waits     int   // WaitErrors honored, of resolvers or of the retrievers of the file
+	grown     int   // requests added to the container by Bundles
+	bundled   int   // number of this request among those bundled in its container, 0 for others
 	file      File
+	ctx       context.Context // of the running ResolveOne call, if any
+	entry     int             // 1 + the index of the resolved file among the container's entries, 0 before
 }
 
 func (r *request) depth() int {
-	if r.parent == nil {
-		return 0
+	return len(r.key) - 1
+}
+
+// compare orders requests by their positions, level by level from the root.
+// Requests are equal if one descends from the other.
+func (r *request) compare(other *request) int {
+	a, b := r.key, other.key
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
 	}
-	return r.parent.depth() + 1
-}
-
-func (r *request) level(other *request, rDepth, oDepth int) (*request, *request) {
-	if rDepth > oDepth {
-		return r.parent.level(other, rDepth-1, oDepth)
-	} else if oDepth > rDepth {
-		return r.level(other.parent, rDepth, oDepth-1)
-	}
-	return r, other
-}
-
-// constraint: r.depth() == other.depth()
-func (r *request) compareLeveled(other *request) int {
-	if r.parent == nil {
-		// we are at the root on both sides
-		return r.order - other.order
-	}
-	diff := r.parent.compareLeveled(other.parent)
-	if diff == 0 {
-		return r.order - other.order
-	}
-	return diff
-}
-
-func (r *request) precedesUnleveled(other *request) bool {
-	r, other = r.level(other, r.depth(), other.depth())
-	return r.compareLeveled(other) < 0
+	return 0
 }
 
 func (r *request) less(other *request) bool {
-	return r.prio < other.prio || r.precedesUnleveled(other)
+	if r.prio != other.prio {
+		return r.prio < other.prio
+	}
+	return r.compare(other) < 0
 }
 
 func (r *request) URL() *url.URL {
 	return r.u
 }
 
+func (r *request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return r.container.ctx
+}
+
 func (r *request) root() *request {
 	if r.parent != nil {
 		return r.parent.root()
@@ -75,14 +78,21 @@ func (r *request) resolvesTo(f File) api.Request {
 }
 
 func (r *request) ResolvesTo(f api.File) api.Request {
-	return r.resolvesTo(online(f, r.root().URL(), r.done))
+	return r.resolvesTo(online(f, r))
 }
 
 func (r *request) Errs(u *url.URL, err error) api.Request {
 	if u == nil {
 		u = r.u
 	}
-	return r.resolvesTo(errored(r.root().u, u, err))
+	return r.resolvesTo(errored(r, u, err))
+}
+
+// fails resolves the request to an errored file, like Errs.
+func (r *request) fails(err error) *request {
+	child := r.child()
+	child.file = errored(r, r.u, err)
+	return child
 }
 
 func (r *request) Deadend(u *url.URL) api.Request {
@@ -90,7 +100,7 @@ func (r *request) Deadend(u *url.URL) api.Request {
 		u = r.u
 	}
 	child := r.child()
-	child.file = offline(r.root().u, u)
+	child.file = offline(r, u)
 	child.u = u
 	return child
 }
@@ -106,17 +116,26 @@ func (r *request) Bundles(urls []*url.URL) []api.Request {
 	// 1 Request -> n Requests. We need to add n-1 to the WaitGroup.
 	// if this URL leads to e.g. an empty folder and this method was still called (error was not,
 	// returned), that means the request is done and adding -1 to wg is still correct.
-	r.container.wg.Add(len(urls) - 1)
+	r.container.grow(len(urls) - 1)
+	r.grown += len(urls) - 1
+	bundled := r.container.bundle(len(urls))
 	children := make([]api.Request, len(urls))
 	for i, u := range urls {
 		child := r.child()
-		child.order = i
+		child.key[len(child.key)-1] = int32(i)
 		child.u = u
+		child.bundled = bundled + i + 1
 		children[i] = child
 	}
 	return children
 }
 
+// discard drops the requests this request generated, e.g. because its resolver failed after all.
+func (r *request) discard() {
+	r.container.grow(-r.grown)
+	r.grown = 0
+}
+
 func (r *request) done() {
 	r.container.wg.Done()
 }
@@ -129,15 +148,17 @@ func (r *request) child() *request {
 	if r.resolved() {
 		panic("child() called on resolved request")
 	}
+	key := make([]int32, len(r.key)+1)
+	copy(key, r.key)
 	return &request{
 		parent:    r,
 		container: r.container,
-		order:     0,
+		key:       key,
+		prio:      r.prio,
 		u:         r.u,
 	}
 }
 
-// the rootRequest takes integers, the float64 part is only relevant for request#Bundles
 func rootRequest(u *url.URL, c *container, order int) *request {
-	return &request{container: c, u: u, order: order}
+	return &request{container: c, u: u, key: []int32{int32(order)}, prio: c.opts.Priority}
 }
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testContainer(n int, prio int) (*container, []*request) {
+	urls := make([]*url.URL, n)
+	for i := range urls {
+		urls[i] = hostURL(fmt.Sprintf("/%d", i))
+	}
+	c := newContainer(context.Background(), urls, ContainerOptions{Priority: prio})
+	reqs := make([]*request, n)
+	for i, u := range urls {
+		reqs[i] = rootRequest(u, c, i)
+	}
+	return c, reqs
+}
+
+func TestRequestOrder(t *testing.T) {
+	_, roots := testContainer(2, 0)
+	children := roots[0].Bundles([]*url.URL{hostURL("/a"), hostURL("/b")})
+	a, b := children[0].(*request), children[1].(*request)
+	grandchild := b.Yields(hostURL("/c")).(*request)
+
+	assert.True(t, roots[0].less(roots[1]))
+	assert.False(t, roots[1].less(roots[0]))
+	assert.True(t, a.less(b))
+	// descendants of an earlier link precede later links
+	assert.True(t, b.less(roots[1]))
+	assert.True(t, grandchild.less(roots[1]))
+	assert.True(t, a.less(grandchild))
+	// a request and its descendants are equal
+	assert.False(t, roots[0].less(a))
+	assert.False(t, a.less(roots[0]))
+	assert.Equal(t, 2, grandchild.depth())
+}
+
+func TestRequestOrderPriority(t *testing.T) {
+	_, normal := testContainer(2, 0)
+	_, urgent := testContainer(2, -1)
+	assert.True(t, urgent[1].less(normal[0]))
+	// a lower priority does not win by position
+	assert.False(t, normal[0].less(urgent[1]))
+}
+
+func TestBatchQueue(t *testing.T) {
+	_, reqs := testContainer(5, 0)
+	q := newBatchQueue(3)
+	q.enqueueAll([]*request{reqs[4], reqs[2], reqs[0], reqs[3], reqs[1]})
+	assert.Equal(t, reqs[:3], <-q.getAll)
+	assert.Len(t, <-q.getAll, 2)
+	q.Finalize()
+	_, ok := <-q.getAll
+	assert.False(t, ok)
+}
+
+func TestBatchQueueRemoveHead(t *testing.T) {
+	_, roots := testContainer(3, 0)
+	reqs := make([]*request, len(roots))
+	for i, r := range roots {
+		reqs[i] = r.ResolvesTo(testFile{r.u, namedProvider("p")}).(*request)
+	}
+	q := newBatchQueue(2)
+	<-q.enqueueAll(reqs)
+	// the dispatcher took the first batch off the heap already
+	<-q.Job(func() {})
+	assert.Equal(t, reqs[0].file, <-q.Remove(reqs[0].file.ID()))
+	assert.Equal(t, reqs[2].file, <-q.Remove(reqs[2].file.ID()))
+	q.Set(reqs[1].file, 5)
+	assert.Equal(t, []File{reqs[1].file}, q.List())
+	assert.Equal(t, reqs[1:2], <-q.getAll)
+}
+
+func BenchmarkRequestLess(b *testing.B) {
+	_, roots := testContainer(2, 0)
+	deep := [2]*request{}
+	for i, r := range roots {
+		for depth := 0; depth < 16; depth++ {
+			r = r.Bundles([]*url.URL{hostURL("/a"), hostURL("/b")})[1].(*request)
+		}
+		deep[i] = r
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deep[0].less(deep[1])
+	}
+}
+
+func BenchmarkQueue100k(b *testing.B) {
+	_, reqs := testContainer(100000, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := newBatchQueue(resolveBatch)
+		// reversed, so that every push has to sift up
+		for i := len(reqs) - 1; i >= 0; i -= resolveBatch {
+			from := i - resolveBatch + 1
+			if from < 0 {
+				from = 0
+			}
+			q.enqueueAll(reqs[from : i+1])
+		}
+		for n := 0; n < len(reqs); {
+			n += len(<-q.getAll)
+		}
+	}
+}
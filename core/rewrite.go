@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule replaces the matches of a regular expression in URLs, e.g. to strip tracking parameters
+// or to map mirrors to the host a provider recognizes.
+type RewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string // may refer to submatches, see regexp.Regexp#Expand
+}
+
+// ParseRewriteRule parses a rule of the form `PATTERN=>REPLACEMENT`,
+// e.g. `^http://(www\.)?=>https://`.
+func ParseRewriteRule(spec string) (RewriteRule, error) {
+	parts := strings.SplitN(spec, "=>", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return RewriteRule{}, fmt.Errorf("invalid rewrite rule %q, expected PATTERN=>REPLACEMENT", spec)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return RewriteRule{}, fmt.Errorf("invalid rewrite rule %q: %v", spec, err)
+	}
+	return RewriteRule{re, parts[1]}, nil
+}
+
+func (r RewriteRule) String() string {
+	return r.Pattern.String() + "=>" + r.Replacement
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r RewriteRule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *RewriteRule) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseRewriteRule(string(text))
+	return err
+}
+
+// canonical applies the rewrite rules to the URL, then lets the first provider that recognizes it
+// canonicalize it. The URL is kept if a rule turns it into an invalid one.
+func (d *Client) canonical(u *url.URL) *url.URL {
+	for _, rule := range d.Rewrites {
+		raw := u.String()
+		rewritten := rule.Pattern.ReplaceAllString(raw, rule.Replacement)
+		if rewritten == raw {
+			continue
+		}
+		if ru, err := url.Parse(rewritten); err != nil || !ru.IsAbs() {
+			d.logger().Warnf("Client#canonical: rule %v turns %v into an invalid URL, ignoring it", rule, raw)
+		} else {
+			u = ru
+		}
+	}
+	for _, p := range d.Providers {
+		if c, ok := p.(Canonicalizer); ok {
+			var cu *url.URL
+			d.safely(p, "Canonicalize", func() error {
+				cu = c.Canonicalize(u)
+				return nil
+			})
+			if cu != nil {
+				return cu
+			}
+		}
+	}
+	return u
+}
+
+// canonicalize canonicalizes the URLs, dropping duplicates. originals holds the URL each canonical one
+// was first given as. The overrides of the options are moved to the canonical URLs.
+func (d *Client) canonicalize(urls []*url.URL, opts ContainerOptions) (canonical, originals []*url.URL, _ ContainerOptions) {
+	seen := make(map[string]bool, len(urls))
+	canonical = make([]*url.URL, 0, len(urls))
+	originals = make([]*url.URL, 0, len(urls))
+	var overrides map[string]ProviderChoice
+	if opts.Overrides != nil {
+		overrides = make(map[string]ProviderChoice, len(opts.Overrides))
+	}
+	for _, u := range urls {
+		cu := d.canonical(u)
+		key := cu.String()
+		if choice, ok := opts.Overrides[u.String()]; ok {
+			overrides[key] = choice
+		}
+		if seen[key] {
+			d.logger().Debugf("Client#canonicalize: dropping duplicate %v (%v)", u, key)
+			continue
+		}
+		seen[key] = true
+		canonical = append(canonical, cu)
+		originals = append(originals, u)
+	}
+	opts.Overrides = overrides
+	return canonical, originals, opts
+}
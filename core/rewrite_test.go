@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mirrorProvider struct{}
+
+func (mirrorProvider) Name() string { return "mirror" }
+func (mirrorProvider) Canonicalize(u *url.URL) *url.URL {
+	if u.Host != "mirror.host" {
+		return nil
+	}
+	c := *u
+	c.Host = "host"
+	return &c
+}
+
+func TestParseRewriteRule(t *testing.T) {
+	rule, err := ParseRewriteRule(`^http://(www\.)?=>https://`)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://", rule.Replacement)
+	for _, spec := range []string{"", "http", "=>https", "(=>x"} {
+		_, err = ParseRewriteRule(spec)
+		assert.Error(t, err, spec)
+	}
+	var cfg ProviderConfig
+	assert.NoError(t, json.Unmarshal([]byte(`{"rewrites": ["[?&]utm_[^&]*=>"]}`), &cfg))
+	assert.Equal(t, "[?&]utm_[^&]*=>", cfg.Rewrites[0].String())
+}
+
+func TestCanonicalize(t *testing.T) {
+	d := NewClientWith(0)
+	d.Providers = Providers{mirrorProvider{}}
+	for _, spec := range []string{`^http://(www\.)?=>https://`, `[?&]utm_[^&]*=>`} {
+		rule, err := ParseRewriteRule(spec)
+		assert.NoError(t, err)
+		d.Rewrites = append(d.Rewrites, rule)
+	}
+	parse := func(raw string) *url.URL {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		return u
+	}
+	urls := []*url.URL{
+		parse("http://www.host/a?utm_source=x"),
+		parse("https://mirror.host/a"),
+		parse("https://host/b"),
+	}
+	choice := ProviderChoice{Retrieve: "premium"}
+	opts := ContainerOptions{Overrides: map[string]ProviderChoice{"https://mirror.host/a": choice}}
+	canonical, originals, opts := d.canonicalize(urls, opts)
+	assert.Len(t, canonical, 2)
+	assert.Equal(t, []*url.URL{urls[0], urls[2]}, originals)
+	assert.Equal(t, "https://host/a", canonical[0].String())
+	assert.Equal(t, "https://host/b", canonical[1].String())
+	assert.Equal(t, map[string]ProviderChoice{"https://host/a": choice}, opts.Overrides)
+}
+
+func TestOriginalURL(t *testing.T) {
+	raw, canonical := &url.URL{Scheme: "http", Host: "www.host", Path: "/a"}, hostURL("/a")
+	c := newContainer(context.Background(), []*url.URL{canonical}, ContainerOptions{})
+	c.raw = []*url.URL{raw}
+	root := rootRequest(canonical, c, 0)
+	f := root.Yields(hostURL("/b")).(*request).ResolvesTo(testFile{hostURL("/b"), namedProvider("p")}).(*request).file
+	assert.Equal(t, raw, f.OriginalURL())
+	assert.Equal(t, canonical, f.CanonicalURL())
+}
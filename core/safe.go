@@ -0,0 +1,18 @@
+package core
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// safely calls into a provider, turning a panic into an error so that a misbehaving
+// provider cannot take down the whole client. The stack trace is logged.
+func (d *Client) safely(p Provider, method string, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.providerLogger(p).Errorf("%v#%v panicked: %v\n%s", p.Name(), method, r, debug.Stack())
+			err = fmt.Errorf("provider %v panicked: %v", p.Name(), r)
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+// panickingProvider panics instead of resolving
+type panickingProvider struct{}
+
+func (panickingProvider) Name() string { return "panicking" }
+
+func (panickingProvider) CanResolve(u *url.URL) api.Resolvability {
+	if u.Path == "/unsupported" {
+		panic("CanResolve")
+	}
+	return api.Single
+}
+
+func (panickingProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	panic("ResolveOne")
+}
+
+func TestProviderPanics(t *testing.T) {
+	d := NewClientWith(0)
+	d.Providers = Providers{panickingProvider{}}
+	c := d.AddURLs([]*url.URL{
+		{Scheme: "http", Host: "host", Path: "/resolve"},
+		{Scheme: "http", Host: "host", Path: "/unsupported"},
+	})
+	d.Resolve()
+	c.Wait()
+	errs := make(map[string]error)
+	for _, f := range c.Files() {
+		assert.Equal(t, FileErrored, f.State)
+		errs[f.File.URL().Path] = f.Err
+	}
+	if assert.Error(t, errs["/resolve"]) {
+		assert.Contains(t, errs["/resolve"].Error(), "panicked")
+	}
+	assert.Equal(t, api.Unsupported, api.KindOf(errs["/unsupported"]))
+}
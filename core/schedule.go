@@ -0,0 +1,126 @@
+package core
+
+import (
+	"math"
+	"sync"
+)
+
+// SchedulePolicy decides in which order resolved files are handed to the retrievers.
+//
+// Rank is called once for every available file entering the resolved queue.
+// Files with a lower rank are retrieved first; files of equal rank are ordered
+// by priority and input order.
+// Calls are serialized by the queue, so implementations need not be thread-safe.
+type SchedulePolicy interface {
+	Rank(File, Container) int64
+}
+
+// SchedulePolicyFunc is an adapter to allow the use of ordinary functions as schedule policies.
+type SchedulePolicyFunc func(File, Container) int64
+
+// Rank calls f(file, c).
+func (f SchedulePolicyFunc) Rank(file File, c Container) int64 {
+	return f(file, c)
+}
+
+// SchedulePolicies lists the names of the built-in schedule policies.
+var SchedulePolicies = []string{"priority", "fifo", "round-robin", "smallest", "provider"}
+
+// SchedulePolicyFor returns a new instance of the built-in schedule policy with the given name,
+// or `nil` if there is none.
+func SchedulePolicyFor(name string) SchedulePolicy {
+	switch name {
+	case "priority":
+		return PriorityOrder()
+	case "fifo":
+		return FIFO()
+	case "round-robin":
+		return RoundRobin()
+	case "smallest":
+		return SmallestFirst()
+	case "provider":
+		return ProviderInterleave()
+	}
+	return nil
+}
+
+// PriorityOrder retrieves files by priority, then by the order they were added in.
+// This is the default policy.
+func PriorityOrder() SchedulePolicy {
+	return SchedulePolicyFunc(func(File, Container) int64 { return 0 })
+}
+
+// FIFO retrieves files in the order they were resolved.
+func FIFO() SchedulePolicy {
+	var seq int64
+	return SchedulePolicyFunc(func(File, Container) int64 {
+		seq++
+		return seq
+	})
+}
+
+// RoundRobin alternates between containers, retrieving one file of each in turn.
+// A container added later takes its turns along with the others, instead of catching up on theirs.
+func RoundRobin() SchedulePolicy {
+	t := &turns{next: make(map[interface{}]int64)}
+	return SchedulePolicyFunc(func(f File, c Container) int64 {
+		turn, first := t.take(c)
+		if first {
+			go func() {
+				c.Wait()
+				t.forget(c)
+			}()
+		}
+		return turn
+	})
+}
+
+// ProviderInterleave alternates between the providers that resolved the files,
+// so a single hoster does not occupy all retrievers.
+func ProviderInterleave() SchedulePolicy {
+	t := &turns{next: make(map[interface{}]int64)}
+	return SchedulePolicyFunc(func(f File, c Container) int64 {
+		turn, _ := t.take(f.Provider().Name())
+		return turn
+	})
+}
+
+// SmallestFirst retrieves small files first. Files of unknown size come last.
+func SmallestFirst() SchedulePolicy {
+	return SchedulePolicyFunc(func(f File, c Container) int64 {
+		if f.LengthUnknown() {
+			return math.MaxInt64
+		}
+		return f.Size()
+	})
+}
+
+// turns ranks the files of each group by the number of files that were ranked before them in the same group.
+// A new group starts at the turn of the group that is furthest behind.
+type turns struct {
+	mtx  sync.Mutex
+	next map[interface{}]int64 // by group
+}
+
+// take returns the turn of the next file of the group, and whether it is the group's first.
+func (t *turns) take(group interface{}) (int64, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	turn, ok := t.next[group]
+	if !ok {
+		first := true
+		for _, n := range t.next {
+			if first || n < turn {
+				turn, first = n, false
+			}
+		}
+	}
+	t.next[group] = turn + 1
+	return turn, !ok
+}
+
+func (t *turns) forget(group interface{}) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.next, group)
+}
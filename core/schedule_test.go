@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobin(t *testing.T) {
+	policy := RoundRobin()
+	a, ra := testContainer(3, 0)
+	b, rb := testContainer(1, 0)
+	rank := func(c *container, r *request) int64 {
+		return policy.Rank(online(testFile{r.u, nil}, r), c)
+	}
+	assert.Equal(t, int64(0), rank(a, ra[0]))
+	assert.Equal(t, int64(1), rank(a, ra[1]))
+	// a container added later does not overtake the first one
+	assert.Equal(t, int64(2), rank(b, rb[0]))
+	assert.Equal(t, int64(2), rank(a, ra[2]))
+}
+
+func TestTurns(t *testing.T) {
+	tr := &turns{next: make(map[interface{}]int64)}
+	for i := 0; i < 5; i++ {
+		tr.take("a")
+	}
+	turn, first := tr.take("b")
+	assert.Equal(t, int64(5), turn)
+	assert.True(t, first)
+	tr.take("b")
+	tr.take("b")
+	turn, first = tr.take("c")
+	assert.Equal(t, int64(5), turn)
+	tr.forget("a")
+	tr.forget("c")
+	assert.Len(t, tr.next, 1)
+}
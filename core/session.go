@@ -0,0 +1,258 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session journals the containers of a Client to a file,
+// so that an interrupted run can be continued after a restart.
+// Changes are appended to the file, which is compacted when the session is opened.
+type Session struct {
+	file    string
+	mtx     sync.Mutex
+	records map[int64]*record // by journal ID
+	next    int64             // journal ID of the next container
+}
+
+// record is the journaled state of a single container
+type record struct {
+	URLs    []string         `json:"urls"`
+	Raw     []string         `json:"raw,omitempty"` // the URLs as they were added, before they were canonicalized
+	Options ContainerOptions `json:"options"`
+	Added   time.Time        `json:"added"`
+	Done    []string         `json:"done,omitempty"` // IDs of retrieved files
+}
+
+// change is a line of the journal
+type change struct {
+	Op      string            `json:"op"` // "add", "done" or "remove"
+	ID      int64             `json:"id"`
+	URLs    []string          `json:"urls,omitempty"`
+	Raw     []string          `json:"raw,omitempty"`
+	Options *ContainerOptions `json:"options,omitempty"`
+	Added   time.Time         `json:"added,omitempty"`
+	Done    []string          `json:"done,omitempty"`
+}
+
+// OpenSession loads the session journaled in the given file.
+// A missing file yields an empty session; it is created on the first change.
+// A change that was cut off at the end of the file, e.g. by a crash, is dropped.
+func OpenSession(file string) (*Session, error) {
+	s := &Session{file: file, records: make(map[int64]*record), next: 1}
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(bs, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var ch change
+		if err = json.Unmarshal(line, &ch); err != nil {
+			// every change is appended with its newline, only the last one can be incomplete.
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		if err = s.replay(ch); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+	}
+	return s, s.compact()
+}
+
+func (s *Session) replay(ch change) error {
+	if ch.ID >= s.next {
+		s.next = ch.ID + 1
+	}
+	switch ch.Op {
+	case "add":
+		r := &record{URLs: ch.URLs, Raw: ch.Raw, Added: ch.Added, Done: ch.Done}
+		if ch.Options != nil {
+			r.Options = *ch.Options
+		}
+		s.records[ch.ID] = r
+	case "done":
+		if r, ok := s.records[ch.ID]; ok {
+			r.Done = append(r.Done, ch.Done...)
+		}
+	case "remove":
+		delete(s.records, ch.ID)
+	default:
+		return fmt.Errorf("unknown journal entry %q", ch.Op)
+	}
+	return nil
+}
+
+// Len returns the number of unfinished containers in this session.
+func (s *Session) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.records)
+}
+
+func (s *Session) add(c *container) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if c.journal != 0 {
+		// restored container
+		return nil
+	}
+	c.journal = s.next
+	s.next++
+	r := &record{URLs: rawURLs(c.id), Raw: rawURLs(c.raw), Options: c.opts, Added: time.Now()}
+	s.records[c.journal] = r
+	return s.append(addition(c.journal, r))
+}
+
+func (s *Session) done(c *container, f File) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r, ok := s.records[c.journal]
+	if !ok {
+		return nil
+	}
+	id := f.ID()
+	r.Done = append(r.Done, id)
+	return s.append(change{Op: "done", ID: c.journal, Done: []string{id}})
+}
+
+func (s *Session) remove(c *container) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.removeRecord(c.journal)
+}
+
+func (s *Session) removeRecord(id int64) error {
+	if _, ok := s.records[id]; !ok {
+		return nil
+	}
+	delete(s.records, id)
+	return s.append(change{Op: "remove", ID: id})
+}
+
+func addition(id int64, r *record) change {
+	opts := r.Options
+	return change{Op: "add", ID: id, URLs: r.URLs, Raw: r.Raw, Options: &opts, Added: r.Added, Done: r.Done}
+}
+
+func rawURLs(urls []*url.URL) []string {
+	ss := make([]string, len(urls))
+	for i, u := range urls {
+		ss[i] = u.String()
+	}
+	return ss
+}
+
+func parseURLs(raws []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, len(raws))
+	for i, raw := range raws {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
+// append writes a change to the end of the journal.
+func (s *Session) append(ch change) error {
+	bs, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(bs, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compact rewrites the journal with the unfinished containers only. It writes a temporary file first,
+// so a crash cannot leave it half-written.
+func (s *Session) compact() error {
+	ids := make([]int64, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, id := range ids {
+		if err := encoder.Encode(addition(id, s.records[id])); err != nil {
+			return err
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// Restore re-adds the unfinished containers of the client's Session.
+// Files that were retrieved before are not downloaded again, partial ones are continued.
+func (d *Client) Restore() []Container {
+	if d.Session == nil {
+		return nil
+	}
+	d.Session.mtx.Lock()
+	ids := make([]int64, 0, len(d.Session.records))
+	for id := range d.Session.records {
+		ids = append(ids, id)
+	}
+	// in the order they were added
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	records := make([]*record, len(ids))
+	for i, id := range ids {
+		records[i] = d.Session.records[id]
+	}
+	d.Session.mtx.Unlock()
+	containers := make([]Container, 0, len(records))
+	for i, r := range records {
+		urls, err := parseURLs(r.URLs)
+		if err != nil {
+			// restoring the rest of the container would not make it the same one
+			d.logger().Errorf("Client#Restore: dropping container added %v: %v", r.Added, err)
+			d.Session.mtx.Lock()
+			err = d.Session.removeRecord(ids[i])
+			d.Session.mtx.Unlock()
+			if err != nil {
+				d.logger().Errorf("Session#save: %v", err)
+			}
+			continue
+		}
+		// journals written before the raw URLs were recorded only know the canonical ones
+		originals, err := parseURLs(r.Raw)
+		if err != nil || len(originals) != len(urls) {
+			originals = nil
+		}
+		retrieved := make(map[string]bool, len(r.Done))
+		for _, id := range r.Done {
+			retrieved[id] = true
+		}
+		containers = append(containers, d.add(urls, originals, r.Options, retrieved, ids[i]))
+	}
+	return containers
+}
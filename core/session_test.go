@@ -0,0 +1,82 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "session.json")
+	s, err := OpenSession(file)
+	assert.NoError(t, err)
+	a, roots := testContainer(1, 0)
+	b, _ := testContainer(1, 0)
+	a.raw = []*url.URL{{Scheme: "http", Host: "www.host", Path: "/0"}}
+	assert.NoError(t, s.add(a))
+	// the same links make another container
+	assert.NoError(t, s.add(b))
+	assert.NotEqual(t, a.journal, b.journal)
+	assert.Equal(t, 2, s.Len())
+	assert.NoError(t, s.done(a, roots[0].ResolvesTo(testFile{roots[0].u, nil}).(*request).file))
+	assert.NoError(t, s.remove(b))
+
+	s, err = OpenSession(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Len())
+	r := s.records[a.journal]
+	assert.Len(t, r.Done, 1)
+	assert.Equal(t, []string{"http://www.host/0"}, r.Raw)
+	// reopening compacts the journal
+	bs, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(bs), "\n"))
+
+	// a record that cannot be restored as it was is dropped
+	r.URLs = append(r.URLs, "%zz")
+	d := NewClientWith(0)
+	d.Session = s
+	assert.Empty(t, d.Restore())
+	assert.Equal(t, 0, s.Len())
+	s, err = OpenSession(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestSessionTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "session.json")
+	journal := `{"op":"add","id":1,"urls":["http://www.host/0"]}
+{"op":"add","id":2,"urls":["http://www.host/1"]}
+{"op":"done","id":1,"do`
+	assert.NoError(t, ioutil.WriteFile(file, []byte(journal), 0644))
+	// the change cut off by a crash is dropped
+	s, err := OpenSession(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Empty(t, s.records[1].Done)
+	s, err = OpenSession(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+
+	// but not a broken one in the middle
+	journal = `{"op":"add","id":1,"urls":["http://www.host/0"]}
+{"op":"done","id":1,"do
+{"op":"add","id":2,"urls":["http://www.host/1"]}
+`
+	assert.NoError(t, ioutil.WriteFile(file, []byte(journal), 0644))
+	_, err = OpenSession(file)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "session.json:2:")
+	}
+}
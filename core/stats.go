@@ -0,0 +1,286 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minSamples is the number of attempts after which the statistics of a provider influence its ranking.
+const minSamples = 3
+
+// statsDelay is how long recorded attempts are kept in memory before they are written,
+// so that the many attempts of a busy client are written at once.
+const statsDelay = 10 * time.Second
+
+// Stats records how well providers retrieve files and persists the records to a file.
+// The client prefers providers that were fast and reliable in the past, see Client.Stats.
+// The records are written a few seconds after an attempt, and when the client is stopped; see Flush.
+type Stats struct {
+	file    string
+	mtx     sync.Mutex
+	records map[string]*ProviderStats // by provider name, and by provider and account ID
+	dirty   bool                      // whether there are records that were not written yet
+	timer   *time.Timer               // of the pending write, nil if there is none
+	err     error                     // of the last pending write, returned by the next record
+}
+
+// ProviderStats are the measurements of a provider, or of one of its accounts.
+type ProviderStats struct {
+	Provider  string        `json:"provider"`
+	Account   string        `json:"account,omitempty"`
+	Attempts  int64         `json:"attempts"`
+	Successes int64         `json:"successes"`
+	Responses int64         `json:"responses"` // attempts the server answered
+	Bytes     int64         `json:"bytes"`
+	Transfer  time.Duration `json:"transfer"` // spent transferring the bytes
+	Waiting   time.Duration `json:"waiting"`  // spent waiting for the responses
+	Updated   time.Time     `json:"updated"`
+}
+
+// attempt is the outcome of a single download
+type attempt struct {
+	provider  Provider
+	account   Account // nil if the provider did not tell, see Accounted
+	ok        bool
+	responded bool
+	bytes     int64
+	transfer  time.Duration
+	waiting   time.Duration // until the response arrived
+}
+
+// OpenStats loads the statistics recorded in the given file.
+// A missing file yields empty statistics; it is created on the first record.
+func OpenStats(file string) (*Stats, error) {
+	s := &Stats{file: file, records: make(map[string]*ProviderStats)}
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bs, &s.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the statistics of all providers and accounts, ordered by provider and account.
+func (s *Stats) List() []ProviderStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	list := make([]ProviderStats, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Provider != list[j].Provider {
+			return list[i].Provider < list[j].Provider
+		}
+		return list[i].Account < list[j].Account
+	})
+	return list
+}
+
+// Of returns the statistics of the provider, across all of its accounts.
+func (s *Stats) Of(provider string) (ProviderStats, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r, ok := s.records[provider]
+	if !ok {
+		return ProviderStats{}, false
+	}
+	return *r, true
+}
+
+// accountOf returns the account the provider retrieves the file with, nil if it does not tell.
+func (d *Client) accountOf(p Provider, file File) (acc Account) {
+	if accounted, ok := p.(Accounted); ok {
+		d.safely(p, "AccountFor", func() error {
+			acc = accounted.AccountFor(file)
+			return nil
+		})
+	}
+	return
+}
+
+// measure records the attempt, if the client records statistics.
+func (d *Client) measure(a attempt) {
+	if d.Stats == nil {
+		return
+	}
+	if err := d.Stats.record(a); err != nil {
+		d.logger().Errorf("Stats#save: %v", err)
+	}
+}
+
+func (s *Stats) record(a attempt) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	keys := []string{a.provider.Name()}
+	if a.account != nil {
+		keys = append(keys, a.provider.Name()+"/"+a.account.ID())
+	}
+	for i, key := range keys {
+		r, ok := s.records[key]
+		if !ok {
+			r = &ProviderStats{Provider: a.provider.Name()}
+			if i > 0 {
+				r.Account = a.account.ID()
+			}
+			s.records[key] = r
+		}
+		r.Attempts++
+		if a.ok {
+			r.Successes++
+		}
+		if a.responded {
+			r.Responses++
+			r.Waiting += a.waiting
+		}
+		r.Bytes += a.bytes
+		r.Transfer += a.transfer
+		r.Updated = time.Now()
+	}
+	s.dirty = true
+	if s.timer == nil && s.file != "" {
+		s.timer = time.AfterFunc(statsDelay, func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.timer = nil
+			s.err = s.save()
+		})
+	}
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Flush writes the records that were not written yet.
+func (s *Stats) Flush() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	err := s.err
+	s.err = nil
+	if s.dirty {
+		if serr := s.save(); serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// save writes the records to a temporary file first, like Session#compact.
+// Statistics without a file are kept in memory only.
+func (s *Stats) save() error {
+	if s.file == "" || !s.dirty {
+		return nil
+	}
+	bs, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.file); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// SuccessRate returns the share of attempts that retrieved the file.
+func (p ProviderStats) SuccessRate() float64 {
+	if p.Attempts == 0 {
+		return 0
+	}
+	return float64(p.Successes) / float64(p.Attempts)
+}
+
+// Throughput returns the bytes retrieved per second, including the time spent waiting for responses.
+func (p ProviderStats) Throughput() float64 {
+	if elapsed := (p.Transfer + p.Waiting).Seconds(); elapsed > 0 {
+		return float64(p.Bytes) / elapsed
+	}
+	return 0
+}
+
+// TimeToFirstByte returns the average time the server took to respond.
+func (p ProviderStats) TimeToFirstByte() time.Duration {
+	if p.Responses == 0 {
+		return 0
+	}
+	return p.Waiting / time.Duration(p.Responses)
+}
+
+// of returns the statistics of the provider, or of the account it retrieves the file with
+// if that has enough attempts already.
+func (s *Stats) of(p Provider, acc Account) ProviderStats {
+	if acc != nil {
+		if st, ok := s.Of(p.Name() + "/" + acc.ID()); ok && st.Attempts >= minSamples {
+			return st
+		}
+	}
+	st, _ := s.Of(p.Name())
+	return st
+}
+
+// rank combines the priorities the providers declared for the file with their statistics.
+// A provider with enough attempts has its priority scaled by its success rate and by how its
+// throughput compares to the average of the others, within a factor of 2 either way.
+// Providers without enough attempts keep their priority, so that they are tried at all.
+func (d *Client) rank(file File, ps Providers, prios []uint) []float64 {
+	scores := make([]float64, len(ps))
+	for i := range ps {
+		scores[i] = float64(prios[i])
+	}
+	if d.Stats == nil {
+		return scores
+	}
+	stats := make([]ProviderStats, len(ps))
+	var total float64
+	var measured int
+	for i, p := range ps {
+		if prios[i] == 0 {
+			continue
+		}
+		if st := d.Stats.of(p, d.accountOf(p, file)); st.Attempts >= minSamples {
+			stats[i] = st
+			if st.Throughput() > 0 {
+				total += st.Throughput()
+				measured++
+			}
+		}
+	}
+	for i, st := range stats {
+		if st.Attempts < minSamples {
+			continue
+		}
+		// smoothed, so that a single failure does not rule a provider out
+		factor := (float64(st.Successes) + 1) / (float64(st.Attempts) + 1)
+		if measured > 1 && st.Throughput() > 0 {
+			speed := st.Throughput() / (total / float64(measured))
+			if speed > 2 {
+				speed = 2
+			} else if speed < 0.5 {
+				speed = 0.5
+			}
+			factor *= speed
+		}
+		scores[i] *= factor
+	}
+	return scores
+}
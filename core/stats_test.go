@@ -0,0 +1,103 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+type testAccount string
+
+func (a testAccount) ID() string { return string(a) }
+
+func TestStatsRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uget")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stats.json")
+	stats, err := OpenStats(file)
+	assert.NoError(t, err)
+	p := namedProvider("fast")
+	assert.NoError(t, stats.record(attempt{provider: p, account: testAccount("alice"), ok: true, responded: true,
+		bytes: 4000, transfer: 3 * time.Second, waiting: time.Second}))
+	assert.NoError(t, stats.record(attempt{provider: p}))
+	// the attempts are written together, later
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, stats.Flush())
+	reopened, err := OpenStats(file)
+	assert.NoError(t, err)
+	list := reopened.List()
+	assert.Len(t, list, 2)
+	st := list[0]
+	assert.Equal(t, "fast", st.Provider)
+	assert.Equal(t, "", st.Account)
+	assert.Equal(t, int64(2), st.Attempts)
+	assert.Equal(t, 0.5, st.SuccessRate())
+	assert.Equal(t, 1000.0, st.Throughput())
+	assert.Equal(t, time.Second, st.TimeToFirstByte())
+	assert.Equal(t, "alice", list[1].Account)
+	assert.Equal(t, int64(1), list[1].Attempts)
+}
+
+func TestStatsRank(t *testing.T) {
+	d := NewClientWith(0)
+	d.Stats = &Stats{records: make(map[string]*ProviderStats)}
+	stats := d.Stats
+	fast, slow, flaky, fresh := namedProvider("fast"), namedProvider("slow"), namedProvider("flaky"), namedProvider("fresh")
+	for i := 0; i < minSamples; i++ {
+		stats.record(attempt{provider: fast, ok: true, bytes: 8000, transfer: time.Second})
+		stats.record(attempt{provider: slow, ok: true, bytes: 1000, transfer: time.Second})
+		stats.record(attempt{provider: flaky})
+	}
+	ps := Providers{fast, slow, flaky, fresh}
+	f := online(testFile{hostURL("/file"), fast}, testRequest("/file"))
+	scores := d.rank(f, ps, []uint{1, 2, 3, 1})
+	// the slow provider declared the higher priority, but the fast one is 8 times as fast
+	assert.True(t, scores[0] > scores[1])
+	assert.True(t, scores[2] < scores[1])
+	assert.Equal(t, 1.0, scores[3])
+	d.Stats = nil
+	assert.Equal(t, []float64{1, 2}, d.rank(f, ps[:2], []uint{1, 2}))
+}
+
+// rotatingProvider retrieves with the first of its accounts that has not failed.
+type rotatingProvider struct {
+	namedProvider
+	accounts []Account
+	failed   map[string]bool
+}
+
+func (p *rotatingProvider) AccountFor(f api.File) Account {
+	for _, acc := range p.accounts {
+		if !p.failed[acc.ID()] {
+			return acc
+		}
+	}
+	return nil
+}
+
+func TestExcludeAccounts(t *testing.T) {
+	d := NewClientWith(0)
+	p := &rotatingProvider{namedProvider("premium"), []Account{testAccount("a"), testAccount("b")}, map[string]bool{}}
+	f := online(testFile{hostURL("/file"), p}, testRequest("/file"))
+	excluded := make(exclusions)
+	excluded.add(p, d.accountOf(p, f))
+	assert.True(t, d.excludes(excluded, p, f))
+	// the provider switched to another account
+	p.failed["a"] = true
+	assert.False(t, d.excludes(excluded, p, f))
+	excluded.add(p, d.accountOf(p, f))
+	p.failed["b"] = true
+	assert.True(t, d.excludes(excluded, p, f))
+	// providers that do not tell their accounts are excluded as a whole
+	other := namedProvider("free")
+	excluded.add(other, d.accountOf(other, f))
+	assert.True(t, d.excludes(excluded, other, f))
+	assert.False(t, d.excludes(excluded, namedProvider("third"), f))
+}
@@ -0,0 +1,111 @@
+package core
+
+import "time"
+
+// FileState denotes where a resolved file is in its lifecycle
+type FileState int
+
+const (
+	// FileQueued - the file is resolved and waiting for a retriever
+	FileQueued FileState = iota
+	// FileDownloading - the file is being retrieved
+	FileDownloading
+	// FileDone - the file was retrieved successfully
+	FileDone
+	// FileSkipped - the file already existed locally or was rejected by the container's filter
+	FileSkipped
+	// FileOffline - the file is not available
+	FileOffline
+	// FileErrored - resolving or retrieving the file failed
+	FileErrored
+	// FileCanceled - the file's container was canceled before the file was done
+	FileCanceled
+	// FileWaiting - the provider asked to wait before the file is retrieved
+	FileWaiting
+)
+
+var fileStates = [...]string{"queued", "downloading", "done", "skipped", "offline", "errored", "canceled", "waiting"}
+
+func (s FileState) String() string {
+	return fileStates[s]
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s FileState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// FileStatus is a snapshot of a single file of a container
+type FileStatus struct {
+	File     File
+	State    FileState
+	Progress int64 // bytes retrieved so far, including those of a previous (continued) download
+	Err      error
+	Until    time.Time // when a waiting file is retried
+}
+
+// ContainerStatus is a snapshot of a container's progress
+type ContainerStatus struct {
+	Resolving      int   `json:"resolving"`
+	Queued         int   `json:"queued"`
+	Downloading    int   `json:"downloading"`
+	Done           int   `json:"done"`
+	Skipped        int   `json:"skipped"`
+	Offline        int   `json:"offline"`
+	Errored        int   `json:"errored"`
+	Canceled       int   `json:"canceled"`
+	Waiting        int   `json:"waiting"`
+	TotalBytes     int64 `json:"total_bytes"`     // of all available files with known length
+	RemainingBytes int64 `json:"remaining_bytes"` // of all queued and downloading files with known length
+	Finished       bool  `json:"finished"`
+	Stopped        bool  `json:"stopped"` // whether Cancel was called
+}
+
+func (e *entry) status() FileStatus {
+	s := FileStatus{File: e.file, State: e.state, Err: e.err}
+	if e.state == FileWaiting {
+		s.Until = e.until
+	}
+	if e.download != nil {
+		s.Progress = e.download.Progress()
+	} else if e.state == FileDone {
+		s.Progress = e.file.Size()
+	}
+	return s
+}
+
+func (s *ContainerStatus) add(e *entry) {
+	switch e.state {
+	case FileQueued:
+		s.Queued++
+	case FileDownloading:
+		s.Downloading++
+	case FileDone:
+		s.Done++
+	case FileSkipped:
+		s.Skipped++
+	case FileOffline:
+		s.Offline++
+		return
+	case FileErrored:
+		s.Errored++
+	case FileCanceled:
+		s.Canceled++
+	case FileWaiting:
+		s.Waiting++
+	}
+	// files that did not resolve, e.g. because their container was canceled meanwhile, have no length.
+	// Neither have offline files, which are recorded as canceled if their container was.
+	if e.file.Err() != nil || e.file.Offline() || e.file.LengthUnknown() {
+		return
+	}
+	s.TotalBytes += e.file.Size()
+	switch e.state {
+	case FileQueued, FileWaiting:
+		s.RemainingBytes += e.file.Size()
+	case FileDownloading:
+		if remaining := e.file.Size() - e.status().Progress; remaining > 0 {
+			s.RemainingBytes += remaining
+		}
+	}
+}
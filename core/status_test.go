@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCanceledOffline(t *testing.T) {
+	c, roots := testContainer(1, 0)
+	c.Cancel()
+	NewClientWith(0).resolved(roots[0].Deadend(nil).(*request))
+	status := c.Status()
+	assert.Equal(t, 1, status.Canceled)
+	assert.Equal(t, int64(0), status.TotalBytes)
+	assert.Len(t, c.Files(), 1)
+}
+
+func TestProgressUnread(t *testing.T) {
+	c, _ := testContainer(1, 0)
+	progress := c.Progress(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	c.mtx.Lock()
+	c.pending = 0
+	c.mtx.Unlock()
+	c.wg.Add(-2)
+	<-c.finished
+	// the final snapshot is delivered although the earlier ones were not read
+	var last ContainerStatus
+	for status := range progress {
+		last = status
+	}
+	assert.True(t, last.Finished)
+}
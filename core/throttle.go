@@ -0,0 +1,101 @@
+package core
+
+import (
+	"github.com/uget/uget/core/api"
+	"github.com/uget/uget/utils/rate"
+)
+
+// DefaultResolvers is the size of the resolver pool if Client.Resolvers is 0
+const DefaultResolvers = 8
+
+// throttle enforces the Limits of a provider
+type throttle struct {
+	limits  api.Limits
+	slots   chan struct{} // nil if the concurrency is unlimited
+	limiter *rate.Limiter // nil if the rate is unlimited
+}
+
+// limits returns the provider's Limits, overridden by those configured in the client.
+func (d *Client) limits(p Provider) api.Limits {
+	var limits api.Limits
+	if t, ok := p.(api.Throttled); ok {
+		d.safely(p, "Limits", func() error {
+			limits = t.Limits()
+			return nil
+		})
+	}
+	if cfg, ok := d.Limits[p.Name()]; ok {
+		if cfg.Concurrency != 0 {
+			limits.Concurrency = cfg.Concurrency
+		}
+		if cfg.RequestsPerSecond != 0 {
+			limits.RequestsPerSecond = cfg.RequestsPerSecond
+		}
+		if cfg.BatchSize != 0 {
+			limits.BatchSize = cfg.BatchSize
+		}
+	}
+	return limits
+}
+
+func (d *Client) throttleFor(p Provider) *throttle {
+	d.throttlesMtx.Lock()
+	defer d.throttlesMtx.Unlock()
+	if d.throttles == nil {
+		d.throttles = make(map[Provider]*throttle)
+		n := d.Resolvers
+		if n <= 0 {
+			n = DefaultResolvers
+		}
+		d.resolving = make(chan struct{}, n)
+	}
+	if p == nil {
+		return nil
+	}
+	t, ok := d.throttles[p]
+	if !ok {
+		t = &throttle{limits: d.limits(p)}
+		if t.limits.Concurrency > 0 {
+			t.slots = make(chan struct{}, t.limits.Concurrency)
+		}
+		if t.limits.RequestsPerSecond > 0 {
+			t.limiter = rate.PerSecond(t.limits.RequestsPerSecond)
+		}
+		d.throttles[p] = t
+	}
+	return t
+}
+
+// throttle blocks until the provider may be called, within both the resolver pool
+// and the provider's limits. The returned function must be called when the call is done.
+func (d *Client) throttle(p Provider) func() {
+	t := d.throttleFor(p)
+	if t != nil && t.slots != nil {
+		t.slots <- struct{}{}
+	}
+	// wait for the rate before taking a slot of the pool, so others can use it meanwhile.
+	if t != nil && t.limiter != nil {
+		t.limiter.WaitN(1)
+	}
+	d.resolving <- struct{}{}
+	return func() {
+		<-d.resolving
+		if t != nil && t.slots != nil {
+			<-t.slots
+		}
+	}
+}
+
+// batches splits the requests for a MultiResolver according to its maximum batch size.
+func (d *Client) batches(p Provider, reqs []api.Request) [][]api.Request {
+	size := d.throttleFor(p).limits.BatchSize
+	if size <= 0 || len(reqs) <= size {
+		return [][]api.Request{reqs}
+	}
+	batches := make([][]api.Request, 0, (len(reqs)+size-1)/size)
+	for len(reqs) > size {
+		batches = append(batches, reqs[:size])
+		reqs = reqs[size:]
+	}
+	return append(batches, reqs)
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+// limitedProvider declares its limits
+type limitedProvider struct{ limits api.Limits }
+
+func (p *limitedProvider) Name() string                            { return "limited" }
+func (p *limitedProvider) CanResolve(u *url.URL) api.Resolvability { return api.Multi }
+func (p *limitedProvider) Limits() api.Limits                      { return p.limits }
+
+func (p *limitedProvider) ResolveMany(rs []api.Request) ([]api.Request, error) {
+	return nil, nil
+}
+
+func TestThrottleLimits(t *testing.T) {
+	p := &limitedProvider{api.Limits{Concurrency: 1, BatchSize: 10}}
+	d := NewClientWith(0)
+	d.Limits = map[string]api.Limits{"limited": {BatchSize: 2}}
+	assert.Equal(t, api.Limits{Concurrency: 1, BatchSize: 2}, d.limits(p))
+
+	batches := d.batches(p, make([]api.Request, 5))
+	if assert.Len(t, batches, 3) {
+		assert.Len(t, batches[0], 2)
+		assert.Len(t, batches[1], 2)
+		assert.Len(t, batches[2], 1)
+	}
+
+	release := d.throttle(p)
+	acquired := make(chan func())
+	go func() { acquired <- d.throttle(p) }()
+	select {
+	case <-acquired:
+		t.Fatal("exceeded the provider's concurrency")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	(<-acquired)()
+}
+
+func TestThrottleResolvers(t *testing.T) {
+	d := NewClientWith(0)
+	d.Resolvers = 2
+	a, b := &limitedProvider{}, &limitedProvider{}
+	releases := []func(){d.throttle(a), d.throttle(b)}
+	acquired := make(chan func())
+	go func() { acquired <- d.throttle(a) }()
+	select {
+	case <-acquired:
+		t.Fatal("exceeded the resolver pool")
+	case <-time.After(20 * time.Millisecond):
+	}
+	releases[1]()
+	(<-acquired)()
+	releases[0]()
+}
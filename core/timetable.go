@@ -0,0 +1,178 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uget/uget/utils/units"
+)
+
+// Window is a recurring period of the week during which the Client runs with specific settings.
+type Window struct {
+	Days  []time.Weekday `json:"days,omitempty"` // every day if empty
+	From  time.Duration  `json:"from"`           // since midnight
+	To    time.Duration  `json:"to"`             // since midnight. If before From, the window ends the next day; if equal, it lasts all day.
+	Pause bool           `json:"pause,omitempty"`
+	Limit int64          `json:"limit,omitempty"` // in bytes per second, 0 means unlimited
+}
+
+// Timetable switches the Client's settings by time of day and weekday.
+// The first Window containing the current time applies. Outside of all windows,
+// retrievers run and Client.Bandwidth is the limit.
+type Timetable []Window
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindow parses a window specification of the form
+//
+//	DAYS FROM-TO [pause] [limit=SIZE]
+//
+// DAYS is `*` or a comma-separated list of days and day ranges, e.g. `mon-fri,sun`.
+// FROM and TO are times of day (`HH:MM`), SIZE is in bytes per second, e.g. `500k`.
+//
+// Examples: `mon-fri 09:00-17:00 limit=200k`, `* 23:00-07:00`, `sat,sun 00:00-24:00 pause`
+func ParseWindow(spec string) (Window, error) {
+	var w Window
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return w, fmt.Errorf("window %q: expected DAYS FROM-TO", spec)
+	}
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return w, fmt.Errorf("window %q: %v", spec, err)
+	}
+	w.Days = days
+	span := strings.Split(fields[1], "-")
+	if len(span) != 2 {
+		return w, fmt.Errorf("window %q: invalid span %q", spec, fields[1])
+	}
+	if w.From, err = parseClock(span[0]); err != nil {
+		return w, fmt.Errorf("window %q: %v", spec, err)
+	}
+	if w.To, err = parseClock(span[1]); err != nil {
+		return w, fmt.Errorf("window %q: %v", spec, err)
+	}
+	for _, f := range fields[2:] {
+		switch {
+		case f == "pause":
+			w.Pause = true
+		case strings.HasPrefix(f, "limit="):
+			if w.Limit, err = units.RAMInBytes(strings.TrimPrefix(f, "limit=")); err != nil {
+				return w, fmt.Errorf("window %q: %v", spec, err)
+			}
+		default:
+			return w, fmt.Errorf("window %q: unknown setting %q", spec, f)
+		}
+	}
+	return w, nil
+}
+
+func parseDays(spec string) ([]time.Weekday, error) {
+	if spec == "*" {
+		return nil, nil
+	}
+	var days []time.Weekday
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.Split(strings.ToLower(part), "-")
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("unknown day %q", bounds[1])
+			}
+		} else if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid day range %q", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func (w Window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns whether the given time lies within this window.
+func (w Window) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	clock := t.Sub(midnight)
+	if w.From == w.To {
+		return w.on(t.Weekday())
+	}
+	if w.From < w.To {
+		return w.on(t.Weekday()) && clock >= w.From && clock < w.To
+	}
+	// the window spans midnight: the early hours belong to the previous day's window.
+	if clock >= w.From {
+		return w.on(t.Weekday())
+	}
+	return clock < w.To && w.on((t.Weekday()+6)%7)
+}
+
+// At returns the window that applies at the given time, if any.
+func (t Timetable) At(now time.Time) (Window, bool) {
+	for _, w := range t {
+		if w.Contains(now) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// follow applies the timetable to the client until it is stopped.
+func (d *Client) follow(t Timetable) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	var current *Window
+	for {
+		w, ok := t.At(time.Now())
+		if !ok {
+			w = Window{Limit: d.Bandwidth}
+		}
+		if current == nil || w.Pause != current.Pause || w.Limit != current.Limit {
+			d.logger().Infof("Client#follow: pause: %v, limit: %v B/s", w.Pause, w.Limit)
+			d.pauseScheduled(w.Pause)
+			d.limiter.SetLimit(w.Limit)
+			current = &w
+		}
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("mon-wed,sun 09:00-17:30 limit=200k")
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Sunday}, w.Days)
+	assert.Equal(t, 9*time.Hour, w.From)
+	assert.Equal(t, 17*time.Hour+30*time.Minute, w.To)
+	assert.Equal(t, int64(200*1024), w.Limit)
+	assert.False(t, w.Pause)
+
+	w, err = ParseWindow("fri-mon 00:00-24:00 pause")
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}, w.Days)
+	assert.True(t, w.Pause)
+
+	for _, spec := range []string{"", "mon", "xyz 09:00-10:00", "* 9-10", "* 09:00-25:00", "* 09:00-10:00 fast"} {
+		_, err = ParseWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	// 2017-10-06 is a Friday
+	at := func(day, hour int) time.Time { return time.Date(2017, 10, day, hour, 0, 0, 0, time.UTC) }
+	night, _ := ParseWindow("fri 23:00-07:00")
+	assert.True(t, night.Contains(at(6, 23)))
+	assert.True(t, night.Contains(at(7, 6)))
+	assert.False(t, night.Contains(at(7, 7)))
+	assert.False(t, night.Contains(at(6, 6)))
+	assert.False(t, night.Contains(at(7, 23)))
+
+	office, _ := ParseWindow("mon-fri 09:00-17:00")
+	table := Timetable{night, office}
+	w, ok := table.At(at(6, 12))
+	assert.True(t, ok)
+	assert.Equal(t, office.From, w.From)
+	_, ok = table.At(at(7, 12))
+	assert.False(t, ok)
+
+	// a window without length lasts all day
+	day, _ := ParseWindow("fri 09:00-09:00")
+	assert.True(t, day.Contains(at(6, 0)))
+	assert.True(t, day.Contains(at(6, 23)))
+	assert.False(t, day.Contains(at(7, 9)))
+}
+
+func TestTimetableKeepsManualPause(t *testing.T) {
+	d := NewClientWith(1)
+	d.Pause()
+	go d.follow(Timetable{{Limit: 1000}})
+	defer d.Stop()
+	for i := 0; i < 100 && d.limiter.Limit() != 1000; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the window does not pause, but the client was paused manually
+	assert.True(t, d.Paused())
+	d.Resume()
+	assert.False(t, d.Paused())
+
+	// and resuming manually does not lift a pause of the timetable
+	d.pauseScheduled(true)
+	d.Pause()
+	d.Resume()
+	assert.True(t, d.Paused())
+	d.pauseScheduled(false)
+	assert.False(t, d.Paused())
+}
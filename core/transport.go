@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/uget/uget/utils/rate"
+)
+
+// Middleware wraps the transport of the client's HTTP requests, those of its downloads and those
+// the providers send with Config.HTTP. See Client.Middleware.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(r).
+func (f RoundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type providerKey struct{}
+
+// RequestProvider returns the provider that sent the request through its Config.HTTP,
+// nil for the client's downloads and requests sent otherwise.
+func RequestProvider(r *http.Request) Provider {
+	p, _ := r.Context().Value(providerKey{}).(Provider)
+	return p
+}
+
+// Logging logs every request with its status and duration, failures as warnings.
+func Logging(log Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			l := log
+			if p := RequestProvider(r); p != nil {
+				l = l.WithFields(Fields{"provider": p.Name()})
+			}
+			start := time.Now()
+			resp, err := next.RoundTrip(r)
+			if err != nil {
+				l.Warnf("HTTP %v %v: %v", r.Method, r.URL, err)
+			} else {
+				l.Debugf("HTTP %v %v: %v in %v", r.Method, r.URL, resp.Status, time.Since(start))
+			}
+			return resp, err
+		})
+	}
+}
+
+// RateLimiting lets at most perSecond requests pass per second to each host.
+// Requests that are canceled while they wait for their turn fail with the error of their context.
+func RateLimiting(perSecond float64) Middleware {
+	var mtx sync.Mutex
+	limiters := make(map[string]*rate.Limiter)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			mtx.Lock()
+			limiter, ok := limiters[r.URL.Host]
+			if !ok {
+				limiter = rate.PerSecond(perSecond)
+				limiters[r.URL.Host] = limiter
+			}
+			mtx.Unlock()
+			if wait := limiter.Reserve(1); wait > 0 {
+				timer := time.NewTimer(wait)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					return nil, r.Context().Err()
+				}
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// Retrying sends idempotent requests again if they failed on the way or the server failed temporarily,
+// up to attempts times in total. The delay between the attempts of a request doubles each time.
+// Responses demanding a wait with Retry-After are passed on, the client waits for them without blocking a worker.
+// Requests with a body are sent again with a fresh copy of it from their GetBody.
+func Retrying(attempts int, delay time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			req, wait := r, delay
+			for attempt := 1; ; attempt++ {
+				if attempt > 1 && r.Body != nil && r.Body != http.NoBody {
+					body, err := r.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					// a RoundTripper must not modify the request
+					req = r.WithContext(r.Context())
+					req.Body = body
+				}
+				resp, err := next.RoundTrip(req)
+				if attempt >= attempts || !idempotent(r) || r.Context().Err() != nil || !temporary(resp, err) {
+					return resp, err
+				}
+				if resp != nil {
+					resp.Body.Close()
+				}
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return nil, r.Context().Err()
+				}
+				wait *= 2
+			}
+		})
+	}
+}
+
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r.Body == nil || r.GetBody != nil
+	}
+	return false
+}
+
+func temporary(resp *http.Response, err error) bool {
+	if err != nil {
+		return err != context.Canceled && err != context.DeadlineExceeded
+	}
+	if _, ok := retryAfter(resp); ok {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Tracing attaches the ClientTrace that trace returns for a request, if any, e.g. to measure DNS lookups and connects.
+func Tracing(trace func(*http.Request) *httptrace.ClientTrace) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if t := trace(r); t != nil {
+				r = r.WithContext(httptrace.WithClientTrace(r.Context(), t))
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// UserAgent sets the User-Agent header of the requests that do not have one.
+func UserAgent(agent string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get("User-Agent") == "" {
+				// a RoundTripper must not modify the request
+				r = r.WithContext(r.Context())
+				r.Header = cloneHeader(r.Header)
+				r.Header.Set("User-Agent", agent)
+			}
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h)+1)
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// transport returns the client's Transport wrapped in its Middleware, the first one outermost.
+// It is built once, changes to the fields afterwards do not apply.
+func (d *Client) transport() http.RoundTripper {
+	d.httpMtx.Lock()
+	defer d.httpMtx.Unlock()
+	if d.chain == nil {
+		d.chain = d.Transport
+		if d.chain == nil {
+			d.chain = http.DefaultTransport
+		}
+		for i := len(d.Middleware) - 1; i >= 0; i-- {
+			d.chain = d.Middleware[i](d.chain)
+		}
+		d.httpClient = &http.Client{Transport: d.chain, Jar: d.Jar}
+	}
+	return d.chain
+}
+
+// HTTPClient returns the HTTP client the provider receives in its Config. It sends the requests
+// through the client's Middleware with its Jar, and gives up after Client.Timeout.
+func (d *Client) HTTPClient(p Provider) *http.Client {
+	chain := d.transport()
+	return &http.Client{
+		Transport: RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return chain.RoundTrip(r.WithContext(context.WithValue(r.Context(), providerKey{}, p)))
+		}),
+		Jar:     d.Jar,
+		Timeout: d.Timeout,
+	}
+}
+
+// downloadClient sends the requests of the downloads, which are not limited by Client.Timeout.
+func (d *Client) downloadClient() *http.Client {
+	d.transport()
+	return d.httpClient
+}
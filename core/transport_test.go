@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientMiddleware(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(r.Header.Get("User-Agent")))
+	}))
+	defer server.Close()
+	var seen []Provider
+	d := NewClientWith(0)
+	d.Middleware = []Middleware{
+		func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				seen = append(seen, RequestProvider(r))
+				return next.RoundTrip(r)
+			})
+		},
+		UserAgent("test"),
+		Retrying(2, time.Millisecond),
+	}
+	p := namedProvider("a")
+	resp, err := d.HTTPClient(p).Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "test", string(body))
+	assert.Equal(t, 2, calls)
+	// the middleware is outside of the retries
+	assert.Equal(t, []Provider{p}, seen)
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err = d.downloadClient().Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []Provider{p, nil}, seen)
+}
+
+func TestRetryingBody(t *testing.T) {
+	var bodies []string
+	failing := RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	req, _ := http.NewRequest("GET", "http://host/", strings.NewReader("query"))
+	resp, err := Retrying(3, time.Millisecond)(failing).RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, []string{"query", "query", "query"}, bodies)
+}
+
+func TestRetryingDelay(t *testing.T) {
+	failing := Retrying(3, 20*time.Millisecond)(RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}))
+	req, _ := http.NewRequest("GET", "http://host/", nil)
+	_, err := failing.RoundTrip(req)
+	assert.NoError(t, err)
+	// the second request starts from the configured delay again: 20ms + 40ms, not 80ms + 160ms
+	start := time.Now()
+	_, err = failing.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 200*time.Millisecond, "waited %v", time.Since(start))
+}
+
+func TestRateLimitingCanceled(t *testing.T) {
+	var calls int
+	limited := RateLimiting(1)(RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}))
+	req, _ := http.NewRequest("GET", "http://host/", nil)
+	_, err := limited.RoundTrip(req)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limited.RoundTrip(req.WithContext(ctx))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uget/uget/core/api"
+)
+
+// DefaultMaxWaits limits the waits providers impose on a single file if Client.MaxWaits is 0
+const DefaultMaxWaits = 20
+
+func (d *Client) maxWaits() int {
+	if d.MaxWaits <= 0 {
+		return DefaultMaxWaits
+	}
+	return d.MaxWaits
+}
+
+// waitedOut is the error of a file whose provider kept asking to wait. It is of the WaitError's kind.
+func waitedOut(we *api.WaitError, waits int) error {
+	return api.Errorf(api.KindOf(we), "gave up after waiting %d times: %v", waits, we)
+}
+
+// wait puts the file back into the queue once the wait imposed by its provider is over.
+// The file is canceled instead if its container is canceled or the client stopped meanwhile.
+func (d *Client) wait(file File, we *api.WaitError) {
+	c := file.request().container
+	until := time.Now().Add(we.Wait)
+	d.fileLogger(file).Infof("Client#wait (%v): %v", file.Name(), we)
+	c.waiting(file, until, we)
+	d.emit(WaitEvent{info(file), until, we})
+	// queue it like the request it was resolved with
+	r := file.request().child()
+	r.file = file
+	sleep(c, we.Wait, func() {
+		d.ResolvedQueue.enqueue(r)
+	}, func() {
+		c.update(file, FileCanceled, nil)
+		d.emit(CancelEvent{info(file), nil})
+		file.done()
+	})
+}
+
+// waitResolving resolves the requests again once the wait imposed by their provider is over.
+// Meanwhile, they are listed as waiting files of their containers.
+func (d *Client) waitResolving(we *api.WaitError, reqs ...*request) {
+	until := time.Now().Add(we.Wait)
+	d.logger().Infof("Client#waitResolving (%v requests): %v", len(reqs), we)
+	byContainer := make(map[*container][]*request)
+	for _, r := range reqs {
+		f := unresolved(r)
+		r.container.waitingUnresolved(f, until, we)
+		d.emit(WaitEvent{info(f), until, we})
+		byContainer[r.container] = append(byContainer[r.container], r)
+	}
+	for c, reqs := range byContainer {
+		c, reqs := c, reqs
+		sleep(c, we.Wait, func() {
+			for _, r := range reqs {
+				c.resumed(unresolved(r))
+			}
+			d.resolverQueue.enqueueAll(reqs)
+		}, func() {
+			for _, r := range reqs {
+				f := unresolved(r)
+				c.resumed(f)
+				c.resolved(f, FileCanceled)
+				d.emit(CancelEvent{info(f), nil})
+				r.done()
+			}
+		})
+	}
+}
+
+// sleep calls wake once the wait is over, or cancel as soon as the container is canceled or the client stopped.
+func sleep(c *container, wait time.Duration, wake, cancel func()) {
+	go func() {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			wake()
+		case <-c.ctx.Done():
+			cancel()
+		}
+	}()
+}
+
+// retryAfter returns the wait demanded by a server that is busy or rate limiting.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	header := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uget/uget/core/api"
+)
+
+func TestWaitResolvingCanceled(t *testing.T) {
+	d := NewClientWith(0)
+	waits := make(chan WaitEvent, 1)
+	d.OnWait(func(e WaitEvent) { waits <- e })
+	c, roots := testContainer(2, 0)
+	c.wg.Done() // the roots are not enqueued
+	d.waitResolving(&api.WaitError{Wait: time.Hour}, roots[0])
+	e := <-waits
+	assert.Equal(t, "0", e.File.Name())
+	status := c.Status()
+	assert.Equal(t, 1, status.Waiting)
+	assert.Equal(t, 1, status.Resolving)
+	assert.Equal(t, FileWaiting, c.Files()[0].State)
+	c.Cancel()
+	c.grow(-1)
+	c.Wait()
+	status = c.Status()
+	assert.Equal(t, 1, status.Canceled)
+	assert.Equal(t, 0, status.Waiting)
+	assert.Equal(t, 0, status.Resolving)
+}
+
+func TestWaitCanceled(t *testing.T) {
+	d := NewClientWith(0)
+	c, roots := testContainer(1, 0)
+	c.wg.Done()
+	r := roots[0].ResolvesTo(testFile{hostURL("/file"), namedProvider("p")}).(*request)
+	c.resolved(r.file, FileQueued)
+	d.wait(r.file, &api.WaitError{Wait: time.Hour})
+	assert.Equal(t, FileWaiting, c.Files()[0].State)
+	c.Cancel()
+	c.Wait()
+	assert.Equal(t, FileCanceled, c.Files()[0].State)
+}
+
+// waitingProvider asks to wait on every call
+type waitingProvider struct {
+	serverProvider
+	resolving bool // asks to wait when resolving, instead of when retrieving
+	calls     int32
+}
+
+func (p *waitingProvider) ResolveOne(r api.Request) ([]api.Request, error) {
+	if p.resolving {
+		atomic.AddInt32(&p.calls, 1)
+		return nil, &api.WaitError{Wait: time.Millisecond}
+	}
+	return p.serverProvider.ResolveOne(r)
+}
+
+func (p *waitingProvider) Retrieve(f api.File) (*http.Request, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return nil, &api.WaitError{Wait: time.Millisecond}
+}
+
+func TestMaxWaits(t *testing.T) {
+	s := newTestServer(map[string]string{"/file": "content"})
+	defer s.Close()
+	for _, resolving := range []bool{false, true} {
+		p := &waitingProvider{serverProvider: serverProvider{s}, resolving: resolving}
+		d := NewClientWith(1)
+		d.Providers = Providers{p}
+		d.MaxWaits = 3
+		c := d.AddURLs([]*url.URL{s.link("/file")})
+		d.Start()
+		c.Wait()
+		assert.Equal(t, int32(4), atomic.LoadInt32(&p.calls))
+		files := c.Files()
+		if assert.Len(t, files, 1) {
+			assert.Equal(t, FileErrored, files[0].State)
+			assert.Equal(t, api.RateLimited, api.KindOf(files[0].Err))
+		}
+	}
+}
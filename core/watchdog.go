@@ -0,0 +1,89 @@
+package core
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Watchdog detects stalled downloads, i.e. connections that stay open but stop delivering.
+// A stalled download is aborted and continued on a new connection, which counts as a retry.
+type Watchdog struct {
+	Timeout  time.Duration `json:"timeout,omitempty"`   // stalled after no bytes arrived for this long, 0 disables
+	MinSpeed int64         `json:"min_speed,omitempty"` // stalled after being slower than this (in bytes per second, see threshold)...
+	Period   time.Duration `json:"period,omitempty"`    // ...for this long, 0 disables
+}
+
+// ErrStalled is the error of a download that was aborted by the Watchdog.
+var ErrStalled = errors.New("download stalled")
+
+func (w Watchdog) enabled() bool {
+	return w.Timeout > 0 || w.MinSpeed > 0 && w.Period > 0
+}
+
+// threshold returns the minimum speed of a download that gets share bytes per second at most (0 if unlimited).
+// Throttled downloads must not stall because of their limit, so the threshold is at most half their share:
+// the limiter does not split the bandwidth evenly among the downloads.
+func (w Watchdog) threshold(share int64) int64 {
+	if share > 0 && share/2 < w.MinSpeed {
+		return share / 2
+	}
+	return w.MinSpeed
+}
+
+// watch aborts the download once it stalls. share returns the bandwidth the download gets at most.
+// The returned function stops watching.
+func (w Watchdog) watch(dl *Download, share func() int64) func() {
+	if !w.enabled() {
+		return func() {}
+	}
+	tick := time.Second
+	if w.Timeout > 0 && w.Timeout/4 < tick {
+		tick = w.Timeout / 4
+	}
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		progress := dl.Progress()
+		last := time.Now()
+		// the speed is measured over consecutive periods
+		periodStart, periodProgress := last, progress
+		for {
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+			now, p := time.Now(), dl.Progress()
+			if p != progress {
+				progress, last = p, now
+			}
+			if w.Timeout > 0 && now.Sub(last) >= w.Timeout {
+				dl.log.Warnf("Watchdog#watch (%v): no data for %v", dl.File.Name(), now.Sub(last))
+				dl.stall()
+				return
+			}
+			if w.MinSpeed > 0 && w.Period > 0 {
+				if elapsed := now.Sub(periodStart); elapsed >= w.Period {
+					if speed := float64(p-periodProgress) / elapsed.Seconds(); speed < float64(w.threshold(share())) {
+						dl.log.Warnf("Watchdog#watch (%v): %.0f B/s for %v", dl.File.Name(), speed, elapsed)
+						dl.stall()
+						return
+					}
+					periodStart, periodProgress = now, p
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// share returns the bandwidth each running download gets on average under the current limit, 0 if unlimited.
+func (d *Client) share() int64 {
+	limit := d.limiter.Limit()
+	if n := int64(atomic.LoadInt32(&d.busy)); n > 1 {
+		limit /= n
+	}
+	return limit
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdogThreshold(t *testing.T) {
+	w := Watchdog{MinSpeed: 10 * 1024}
+	assert.Equal(t, int64(10*1024), w.threshold(0))
+	assert.Equal(t, int64(10*1024), w.threshold(1024*1024))
+	assert.Equal(t, int64(2048), w.threshold(4096))
+
+	d := NewClientWith(2)
+	d.limiter.SetLimit(8192)
+	d.busy = 2
+	assert.Equal(t, int64(4096), d.share())
+	d.limiter.SetLimit(0)
+	assert.Equal(t, int64(0), d.share())
+}
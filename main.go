@@ -5,10 +5,8 @@ import (
 
 	_ "github.com/uget/providers"
 	"github.com/uget/uget/cli"
-	"github.com/uget/uget/utils"
 )
 
 func main() {
-	utils.InitLogger()
 	os.Exit(cli.RunApp(os.Args))
 }
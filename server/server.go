@@ -4,32 +4,89 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/Unknwon/macaron"
 	"github.com/uget/uget/core"
+	"github.com/uget/uget/core/api"
+	"github.com/uget/uget/utils"
 )
 
 // Server listens for HTTP requests that manipulate files
 type Server struct {
-	BindAddr  string    `json:"bind_address,omitempty"`
-	Port      uint16    `json:"port"`
-	StartedAt time.Time `json:"started_at"`
+	BindAddr  string              `json:"bind_address,omitempty"`
+	Port      uint16              `json:"port"`
+	StartedAt time.Time           `json:"started_at"`
+	Timetable core.Timetable      `json:"timetable,omitempty"`
+	Bandwidth int64               `json:"bandwidth,omitempty"`
+	Autoscale *core.Autoscale     `json:"autoscale,omitempty"`
+	Providers core.ProviderConfig `json:"providers"`
+	Log       core.Logger         `json:"-"` // receives the messages of the server and its client, they are dropped if nil
 }
 
 var downloader = core.NewClient()
 
+// logger of the running server
+var logger = core.NopLogger
+
+// containers added since the server started, by ID.
+// The server numbers them itself: the same links added twice make two containers with the same core.ContainerID.
+var containers = struct {
+	sync.Mutex
+	byID map[string]core.Container
+	last int
+}{byID: make(map[string]core.Container)}
+
+// register assigns IDs to the containers and returns them
+func register(cs ...core.Container) []string {
+	containers.Lock()
+	defer containers.Unlock()
+	ids := make([]string, len(cs))
+	for i, c := range cs {
+		containers.last++
+		ids[i] = strconv.Itoa(containers.last)
+		containers.byID[ids[i]] = c
+	}
+	return ids
+}
+
 type macaronLog struct{}
 
 func (w macaronLog) Write(p []byte) (int, error) {
-	logrus.Info(strings.TrimSpace(string(p)))
+	logger.Infof("%s", strings.TrimSpace(string(p)))
 	return len(p), nil
 }
 
 // Run starts the server
 func (s *Server) Run() {
+	if s.Log != nil {
+		logger = s.Log
+	}
+	downloader.Log = logger
+	downloader.Middleware = append(downloader.Middleware, core.Logging(logger), core.UserAgent("uget/"+core.Version))
+	// the backlog is journaled, so a restarted server continues where it left off.
+	if session, err := core.OpenSession(utils.SessionPath()); err != nil {
+		logger.Errorf("Server#Run: not journaling, could not read session: %v", err)
+	} else {
+		downloader.Session = session
+		restored := downloader.Restore()
+		register(restored...)
+		logger.Infof("Server#Run: restored %d containers", len(restored))
+	}
+	if stats, err := core.OpenStats(utils.StatsPath()); err != nil {
+		logger.Errorf("Server#Run: not ranking by statistics, could not read them: %v", err)
+	} else {
+		downloader.Stats = stats
+	}
+	downloader.UseProviderConfig(s.Providers)
+	downloader.Timetable = s.Timetable
+	downloader.Bandwidth = s.Bandwidth
+	downloader.Autoscale = s.Autoscale
+	downloader.Start()
 	m := macaron.NewWithLogger(macaronLog{})
 	m.Use(macaron.Renderer())
 	// JSON API
@@ -41,6 +98,8 @@ func (s *Server) Run() {
 			m.Get("/:id", s.showContainer)
 			m.Delete("/:id", s.deleteContainer)
 		})
+		m.Get("/concurrency", s.showConcurrency)
+		m.Put("/concurrency", s.setConcurrency)
 	})
 	// CLICK'N'LOAD v2
 	cnl(m)
@@ -49,33 +108,113 @@ func (s *Server) Run() {
 }
 
 func addLinks(links []string) {
-	logrus.Debugf("Added %v links!", len(links))
+	logger.Debugf("Added %v links!", len(links))
 }
 
 func (s *Server) createContainer(c *macaron.Context) {
-	var container struct {
-		string `json:"p"`
+	var body struct {
+		URLs    []string              `json:"urls"`
+		Options core.ContainerOptions `json:"options"`
 	}
 	decoder := json.NewDecoder(c.Req.Body().ReadCloser())
-	if decoder.Decode(&container) != nil {
-		c.Render.Error(http.StatusNotFound, "Invalid JSON.")
+	if decoder.Decode(&body) != nil || len(body.URLs) == 0 {
+		c.Render.Error(http.StatusBadRequest, "Invalid JSON.")
+		return
 	}
-	c.Render.RawData(http.StatusOK, []byte("okay!"))
+	urls := make([]*url.URL, len(body.URLs))
+	for i, raw := range body.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() {
+			c.Render.Error(http.StatusBadRequest, fmt.Sprintf("Invalid URL %s.", raw))
+			return
+		}
+		urls[i] = u
+	}
+	container := downloader.AddURLsWith(urls, body.Options)
+	c.JSON(http.StatusCreated, map[string]string{"id": register(container)[0]})
 }
 
-func (s *Server) listContainers(c *macaron.Context) {
+type containerJSON struct {
+	ID      string                `json:"id"`
+	Options core.ContainerOptions `json:"options"`
+	Status  core.ContainerStatus  `json:"status"`
+	Files   []fileJSON            `json:"files,omitempty"`
+}
 
+type fileJSON struct {
+	Name     string         `json:"name"`
+	URL      string         `json:"url"`
+	State    core.FileState `json:"state"`
+	Progress int64          `json:"progress"`
+	Error    string         `json:"error,omitempty"`
+	Kind     api.ErrorKind  `json:"error_kind,omitempty"`
+	Until    *time.Time     `json:"waiting_until,omitempty"` // when a waiting file is retried
 }
 
-func (s *Server) showContainer(c *macaron.Context) {
+func (s *Server) listContainers(c *macaron.Context) {
+	containers.Lock()
+	list := make([]containerJSON, 0, len(containers.byID))
+	for id, container := range containers.byID {
+		list = append(list, containerJSON{ID: id, Options: container.Options(), Status: container.Status()})
+	}
+	containers.Unlock()
+	c.JSON(http.StatusOK, list)
+}
 
+func (s *Server) showContainer(c *macaron.Context) {
+	containers.Lock()
+	container, ok := containers.byID[c.Params("id")]
+	containers.Unlock()
+	if !ok {
+		c.Render.Error(http.StatusNotFound, "No such container.")
+		return
+	}
+	body := containerJSON{ID: c.Params("id"), Options: container.Options(), Status: container.Status()}
+	for _, f := range container.Files() {
+		file := fileJSON{Name: f.File.Name(), URL: f.File.URL().String(), State: f.State, Progress: f.Progress}
+		if f.Err != nil {
+			file.Error, file.Kind = f.Err.Error(), api.KindOf(f.Err)
+		}
+		if f.State == core.FileWaiting {
+			until := f.Until
+			file.Until = &until
+		}
+		body.Files = append(body.Files, file)
+	}
+	c.JSON(http.StatusOK, body)
 }
 
 func (s *Server) deleteContainer(c *macaron.Context) {
-	fmt.Printf("Deleting %s\n", c.Params("id"))
+	containers.Lock()
+	container, ok := containers.byID[c.Params("id")]
+	delete(containers.byID, c.Params("id"))
+	containers.Unlock()
+	if !ok {
+		c.Render.Error(http.StatusNotFound, "No such container.")
+		return
+	}
+	container.Cancel()
+	logger.Infof("Server#deleteContainer: canceled and removed %v", c.Params("id"))
 	c.Status(http.StatusNoContent)
 }
 
+func (s *Server) showConcurrency(c *macaron.Context) {
+	c.JSON(http.StatusOK, map[string]int{"concurrency": downloader.Concurrency()})
+}
+
+func (s *Server) setConcurrency(c *macaron.Context) {
+	var body struct {
+		Concurrency int `json:"concurrency"`
+	}
+	decoder := json.NewDecoder(c.Req.Body().ReadCloser())
+	if decoder.Decode(&body) != nil || body.Concurrency < 1 {
+		c.Render.Error(http.StatusBadRequest, "Invalid JSON.")
+		return
+	}
+	downloader.SetConcurrency(body.Concurrency)
+	c.JSON(http.StatusOK, map[string]int{"concurrency": downloader.Concurrency()})
+}
+
 func as(ctype string) func(http.ResponseWriter) {
 	return func(w http.ResponseWriter) {
 		w.Header().Set("Content-Type", fmt.Sprintf("%s; charset=utf-8", ctype))
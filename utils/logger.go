@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"os"
 	path "path/filepath"
 	"strings"
@@ -10,23 +11,39 @@ import (
 	isatty "github.com/mattn/go-isatty"
 )
 
-// InitLogger initiates the logger to log into the APP_USER_LOG path
-func InitLogger() {
-	logrus.SetLevel(logrus.DebugLevel)
-	if strings.HasSuffix(os.Args[0], ".test") || !isatty.IsTerminal(os.Stderr.Fd()) {
-		logrus.SetOutput(os.Stderr)
-		return
+// InitLogger sets up the standard logger with the given level ("debug", "info", "warn" or "error")
+// and format ("text" or "json"). Without a file, it logs into the APP_USER_LOG path if stderr is a terminal,
+// so as not to interfere with the progress output, and to stderr otherwise.
+func InitLogger(level, file, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
 	}
-
-	logfile := path.Join(app.UserLog(), time.Now().Local().Format("2006-01-02.log"))
-	err1 := os.MkdirAll(path.Dir(logfile), 0755)
-	f, err2 := os.OpenFile(logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	logrus.SetLevel(lvl)
+	switch format {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	if file == "" {
+		if strings.HasSuffix(os.Args[0], ".test") || !isatty.IsTerminal(os.Stderr.Fd()) {
+			logrus.SetOutput(os.Stderr)
+			return nil
+		}
+		file = path.Join(app.UserLog(), time.Now().Local().Format("2006-01-02.log"))
+	}
+	err1 := os.MkdirAll(path.Dir(file), 0755)
+	f, err2 := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err1 != nil || err2 != nil {
 		logrus.SetOutput(os.Stderr)
 		logrus.WithFields(logrus.Fields{
-			"file": logfile,
+			"file": file,
 		}).Error("Could not create file or parent directories.")
 	} else {
 		logrus.SetOutput(f)
 	}
+	return nil
 }
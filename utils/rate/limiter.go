@@ -0,0 +1,75 @@
+package rate
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter caps the combined throughput of all readers sharing it.
+// It is a token bucket holding at most one second worth of bytes.
+type Limiter struct {
+	mtx    sync.Mutex
+	limit  float64 // bytes per second, 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter that lets bps bytes pass per second. 0 means unlimited.
+func NewLimiter(bps int64) *Limiter {
+	return &Limiter{limit: float64(bps), tokens: float64(bps), last: time.Now()}
+}
+
+// PerSecond returns a Limiter that lets n events pass per second, e.g. requests. Pass them with WaitN(1).
+// n may be fractional, e.g. 0.5 for one event every two seconds.
+func PerSecond(n float64) *Limiter {
+	return &Limiter{limit: n, tokens: math.Max(n, 1), last: time.Now()}
+}
+
+// SetLimit changes the number of bytes per second. 0 means unlimited.
+func (l *Limiter) SetLimit(bps int64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.refill()
+	l.limit = float64(bps)
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+}
+
+// Limit returns the number of bytes per second. 0 means unlimited.
+func (l *Limiter) Limit() int64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return int64(l.limit)
+}
+
+// WaitN blocks until n more bytes may pass.
+func (l *Limiter) WaitN(n int) {
+	time.Sleep(l.Reserve(n))
+}
+
+// Reserve takes n more bytes and returns how long the caller has to wait before they may pass.
+func (l *Limiter) Reserve(n int) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.limit == 0 {
+		return 0
+	}
+	l.refill()
+	// go into debt, so reads larger than the bucket still pass eventually.
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		return time.Duration(-l.tokens / l.limit * float64(time.Second))
+	}
+	return 0
+}
+
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limit
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+	l.last = now
+}
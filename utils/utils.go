@@ -17,3 +17,18 @@ func ConfigPath() string {
 func AccountsPath() string {
 	return path.Join(ConfigPath(), "accounts.json")
 }
+
+// SessionPath denotes the file where the queue of the server is journaled
+func SessionPath() string {
+	return path.Join(ConfigPath(), "session.json")
+}
+
+// ProvidersPath denotes the file where the order and settings of the providers are configured
+func ProvidersPath() string {
+	return path.Join(ConfigPath(), "providers.json")
+}
+
+// StatsPath denotes the file where the statistics of the providers are recorded
+func StatsPath() string {
+	return path.Join(ConfigPath(), "stats.json")
+}